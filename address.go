@@ -0,0 +1,138 @@
+//go:build windows
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package wintun
+
+import (
+	"net/netip"
+	"time"
+
+	"golang.org/x/sys/windows"
+)
+
+// AllWintunAddresses returns the unicast addresses assigned to every Wintun
+// adapter on the system, keyed by adapter LUID. Adapters without addresses are
+// included with an empty slice.
+func AllWintunAddresses() (map[uint64][]netip.Prefix, error) {
+	luids, err := wintunLUIDs()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := getUnicastIPAddressTable(windows.AF_UNSPEC)
+	if err != nil {
+		return nil, err
+	}
+	addresses := make(map[uint64][]netip.Prefix, len(luids))
+	for _, luid := range luids {
+		addresses[luid] = []netip.Prefix{}
+	}
+	for i := range rows {
+		prefixes, ok := addresses[rows[i].InterfaceLUID]
+		if !ok {
+			continue
+		}
+		addresses[rows[i].InterfaceLUID] = append(prefixes, netip.PrefixFrom(rows[i].Address.addr(), int(rows[i].OnLinkPrefixLength)))
+	}
+	return addresses, nil
+}
+
+// SetIPAddress adds address, with its prefix length as the on-link prefix, to
+// the adapter's unicast addresses.
+func (wintun *Adapter) SetIPAddress(address netip.Prefix) error {
+	if !address.IsValid() {
+		return windows.ERROR_INVALID_PARAMETER
+	}
+	var row mibUnicastIPAddressRow
+	initializeUnicastIPAddressEntry(&row)
+	row.InterfaceLUID = wintun.LUID()
+	err := row.Address.setAddr(address.Addr())
+	if err != nil {
+		return err
+	}
+	row.OnLinkPrefixLength = uint8(address.Bits())
+	if skipDryRun("add address %v on interface %d", address, row.InterfaceLUID) {
+		return nil
+	}
+	return createUnicastIPAddressEntry(&row)
+}
+
+// addresses returns the adapter's unicast address rows.
+func (wintun *Adapter) addresses() ([]mibUnicastIPAddressRow, error) {
+	rows, err := getUnicastIPAddressTable(windows.AF_UNSPEC)
+	if err != nil {
+		return nil, err
+	}
+	luid := wintun.LUID()
+	own := rows[:0]
+	for i := range rows {
+		if rows[i].InterfaceLUID == luid {
+			own = append(own, rows[i])
+		}
+	}
+	return own, nil
+}
+
+// FlushAddresses removes all unicast addresses from the adapter. Windows
+// applies the removal asynchronously; use WaitNoAddresses to wait for it.
+func (wintun *Adapter) FlushAddresses() error {
+	rows, err := wintun.addresses()
+	if err != nil {
+		return err
+	}
+	for i := range rows {
+		if skipDryRun("remove address %v on interface %d", rows[i].Address.addr(), rows[i].InterfaceLUID) {
+			continue
+		}
+		err = deleteUnicastIPAddressEntry(&rows[i])
+		if err != nil && err != windows.ERROR_NOT_FOUND {
+			return err
+		}
+	}
+	return nil
+}
+
+// WaitNoAddresses polls until the adapter has no unicast addresses left,
+// typically shortly after FlushAddresses, so that addresses assigned next do
+// not briefly coexist with the old ones. It returns windows.WAIT_TIMEOUT if
+// addresses remain once timeout has elapsed.
+func (wintun *Adapter) WaitNoAddresses(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		rows, err := wintun.addresses()
+		if err != nil {
+			return err
+		}
+		if len(rows) == 0 {
+			return nil
+		}
+		if !time.Now().Before(deadline) {
+			return windows.WAIT_TIMEOUT
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// SetPointToPoint configures the adapter as one end of a point-to-point link:
+// it assigns local as a host address, /32 or /128, and adds an on-link host
+// route to remote, so that only the peer is reachable directly and other
+// destinations need routes through it. local and remote must be of the same
+// family. Addresses and routes that already exist are kept.
+func (wintun *Adapter) SetPointToPoint(local, remote netip.Addr) error {
+	local, remote = local.Unmap(), remote.Unmap()
+	if !local.IsValid() || !remote.IsValid() || local.Is4() != remote.Is4() || local == remote {
+		return windows.ERROR_INVALID_PARAMETER
+	}
+	err := wintun.SetIPAddress(netip.PrefixFrom(local, local.BitLen()))
+	if err != nil && err != windows.ERROR_OBJECT_ALREADY_EXISTS {
+		return err
+	}
+	err = wintun.AddRoute(RouteEntry{Destination: netip.PrefixFrom(remote, remote.BitLen())})
+	if err == windows.ERROR_OBJECT_ALREADY_EXISTS {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,54 @@
+//go:build windows
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package wintun
+
+import (
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	modnci                   = windows.NewLazySystemDLL("nci.dll")
+	procNciSetConnectionName = modnci.NewProc("NciSetConnectionName")
+)
+
+// Alias returns the interface alias of the adapter, the name by which netsh
+// and the PowerShell NetAdapter and NetTCPIP cmdlets address it.
+func (wintun *Adapter) Alias() (string, error) {
+	luid := wintun.LUID()
+	var alias [ifMaxStringSize + 1]uint16
+	err := convertInterfaceLUIDToAlias(&luid, &alias[0], uintptr(len(alias)))
+	if err != nil {
+		return "", err
+	}
+	return windows.UTF16ToString(alias[:]), nil
+}
+
+// SetAlias renames the adapter's connection, which sets its interface alias.
+// It uses NciSetConnectionName, as the Network Connections folder does when
+// renaming a connection. Names must be unique among connections.
+func (wintun *Adapter) SetAlias(alias string) error {
+	if alias == "" || len(alias) > ifMaxStringSize {
+		return windows.ERROR_INVALID_PARAMETER
+	}
+	alias16, err := windows.UTF16PtrFromString(alias)
+	if err != nil {
+		return err
+	}
+	guid, err := wintun.guid()
+	if err != nil {
+		return err
+	}
+	r0, _, _ := syscall.Syscall(procNciSetConnectionName.Addr(), 2, uintptr(unsafe.Pointer(&guid)), uintptr(unsafe.Pointer(alias16)), 0)
+	if r0 != 0 {
+		return syscall.Errno(r0)
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+//go:build windows
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package wintun
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/windows"
+)
+
+// ioBackoff is the policy for retrying a packet operation on a full ring.
+type ioBackoff struct {
+	initial time.Duration
+	max     time.Duration
+	factor  float64
+}
+
+var (
+	ioBackoffMu     sync.Mutex
+	ioBackoffPolicy = ioBackoff{initial: 50 * time.Microsecond, max: 10 * time.Millisecond, factor: 2}
+)
+
+// SetIOBackoff sets how the operations that retry on a full ring, such as
+// AllocateSendPacketContext, wait between attempts: first initial, then each
+// wait factor times longer than the previous one, up to max. Shorter waits
+// lower latency when the ring drains quickly, at the cost of more CPU spent
+// retrying. The defaults are 50µs, 10ms and 2. A factor below 1 is treated as
+// 1, and a max below initial as initial.
+func SetIOBackoff(initial, max time.Duration, factor float64) {
+	if initial <= 0 {
+		initial = time.Microsecond
+	}
+	if max < initial {
+		max = initial
+	}
+	if factor < 1 {
+		factor = 1
+	}
+	ioBackoffMu.Lock()
+	ioBackoffPolicy = ioBackoff{initial, max, factor}
+	ioBackoffMu.Unlock()
+}
+
+func currentIOBackoff() ioBackoff {
+	ioBackoffMu.Lock()
+	defer ioBackoffMu.Unlock()
+	return ioBackoffPolicy
+}
+
+// wait sleeps for delay or until ctx is done, and returns the next delay.
+func (b ioBackoff) wait(ctx context.Context, delay time.Duration) (time.Duration, error) {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	case <-timer.C:
+	}
+	delay = time.Duration(float64(delay) * b.factor)
+	if delay > b.max {
+		delay = b.max
+	}
+	return delay, nil
+}
+
+// AllocateSendPacketContext is like AllocateSendPacket, but when the ring is
+// full it retries, waiting as configured by SetIOBackoff, until space becomes
+// available or ctx is done.
+func (session Session) AllocateSendPacketContext(ctx context.Context, packetSize int) ([]byte, error) {
+	backoff := currentIOBackoff()
+	delay := backoff.initial
+	for {
+		packet, err := session.AllocateSendPacket(packetSize)
+		if err != windows.ERROR_BUFFER_OVERFLOW {
+			return packet, err
+		}
+		delay, err = backoff.wait(ctx, delay)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
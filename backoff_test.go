@@ -0,0 +1,70 @@
+//go:build windows
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package wintun
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSetIOBackoff(t *testing.T) {
+	defer func(policy ioBackoff) {
+		ioBackoffMu.Lock()
+		ioBackoffPolicy = policy
+		ioBackoffMu.Unlock()
+	}(currentIOBackoff())
+
+	tests := []struct {
+		name         string
+		initial, max time.Duration
+		factor       float64
+		want         ioBackoff
+	}{
+		{"valid", time.Millisecond, 8 * time.Millisecond, 1.5, ioBackoff{time.Millisecond, 8 * time.Millisecond, 1.5}},
+		{"zero initial", 0, time.Millisecond, 2, ioBackoff{time.Microsecond, time.Millisecond, 2}},
+		{"max below initial", time.Millisecond, time.Microsecond, 2, ioBackoff{time.Millisecond, time.Millisecond, 2}},
+		{"factor below 1", time.Millisecond, time.Second, 0.5, ioBackoff{time.Millisecond, time.Second, 1}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			SetIOBackoff(test.initial, test.max, test.factor)
+			if got := currentIOBackoff(); got != test.want {
+				t.Errorf("policy = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestIOBackoffWait(t *testing.T) {
+	backoff := ioBackoff{initial: time.Millisecond, max: 5 * time.Millisecond, factor: 2}
+	delay := backoff.initial
+	for _, want := range []time.Duration{2 * time.Millisecond, 4 * time.Millisecond, 5 * time.Millisecond, 5 * time.Millisecond} {
+		start := time.Now()
+		next, err := backoff.wait(context.Background(), delay)
+		if err != nil {
+			t.Fatalf("wait failed: %v", err)
+		}
+		if elapsed := time.Since(start); elapsed < delay {
+			t.Errorf("wait(%v) returned after %v", delay, elapsed)
+		}
+		if next != want {
+			t.Errorf("wait(%v) returned next delay %v, want %v", delay, next, want)
+		}
+		delay = next
+	}
+}
+
+func TestIOBackoffWaitCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	backoff := ioBackoff{initial: time.Hour, max: time.Hour, factor: 2}
+	if _, err := backoff.wait(ctx, time.Hour); err != context.Canceled {
+		t.Fatalf("wait returned %v, want context.Canceled", err)
+	}
+}
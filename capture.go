@@ -0,0 +1,162 @@
+//go:build windows
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package wintun
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// ErrCaptureRunning is returned when starting a capture on a session that
+// already has one running.
+var ErrCaptureRunning = errors.New("A capture is already running on this session")
+
+const (
+	pcapGlobalHeaderSize = 24
+	pcapRecordHeaderSize = 16
+	pcapLinkTypeRaw      = 101 // LINKTYPE_RAW: packets begin with an IPv4 or IPv6 header
+)
+
+// rotatingCapture writes the packets of a session to a series of pcap files.
+type rotatingCapture struct {
+	mu       sync.Mutex
+	dir      string
+	maxSize  int64
+	maxFiles int
+	file     *os.File
+	writer   *bufio.Writer
+	size     int64
+	files    []string
+	sequence int
+	failed   bool
+	adapter  *Adapter // For the tags of the log line on failure
+}
+
+// DumpToRotating captures the packets the session receives and sends to pcap
+// files in dir, starting a new file whenever the current one would exceed
+// maxSize bytes and deleting the oldest ones to keep at most maxFiles, so that
+// a capture can run indefinitely in bounded disk space. maxSize must leave
+// room for a packet of PacketSizeMax bytes. Each file is a standalone capture
+// of raw IP packets with its own header. Writes are buffered; calling stop
+// flushes and closes the current file. A session runs at most one capture at a
+// time.
+func (session Session) DumpToRotating(dir string, maxSize int64, maxFiles int) (stop func(), err error) {
+	if maxSize < pcapGlobalHeaderSize+pcapRecordHeaderSize+PacketSizeMax || maxFiles < 1 {
+		return nil, windows.ERROR_INVALID_PARAMETER
+	}
+	capture := &rotatingCapture{dir: dir, maxSize: maxSize, maxFiles: maxFiles, adapter: session.state.adapter}
+	err = capture.rotate()
+	if err != nil {
+		return nil, err
+	}
+	if !atomic.CompareAndSwapPointer(&session.state.capture, nil, unsafe.Pointer(capture)) {
+		capture.close()
+		os.Remove(capture.files[0])
+		return nil, ErrCaptureRunning
+	}
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			atomic.CompareAndSwapPointer(&session.state.capture, unsafe.Pointer(capture), nil)
+			capture.mu.Lock()
+			defer capture.mu.Unlock()
+			capture.close()
+		})
+	}, nil
+}
+
+// captured writes packet to the session's capture, if one is running.
+func (state *sessionState) captured(packet []byte) {
+	if capture := (*rotatingCapture)(atomic.LoadPointer(&state.capture)); capture != nil {
+		capture.write(packet)
+	}
+}
+
+func (capture *rotatingCapture) write(packet []byte) {
+	capture.mu.Lock()
+	defer capture.mu.Unlock()
+	if capture.failed || capture.file == nil {
+		return
+	}
+	recordSize := int64(pcapRecordHeaderSize + len(packet))
+	if capture.size+recordSize > capture.maxSize {
+		capture.close()
+		if err := capture.rotate(); err != nil {
+			capture.fail(err)
+			return
+		}
+	}
+	now := time.Now()
+	var header [pcapRecordHeaderSize]byte
+	binary.LittleEndian.PutUint32(header[0:], uint32(now.Unix()))
+	binary.LittleEndian.PutUint32(header[4:], uint32(now.Nanosecond()/1000))
+	binary.LittleEndian.PutUint32(header[8:], uint32(len(packet)))
+	binary.LittleEndian.PutUint32(header[12:], uint32(len(packet)))
+	capture.writer.Write(header[:])
+	_, err := capture.writer.Write(packet)
+	if err != nil {
+		capture.fail(err)
+		return
+	}
+	capture.size += recordSize
+}
+
+// rotate starts a new capture file and deletes the oldest ones beyond
+// maxFiles.
+func (capture *rotatingCapture) rotate() error {
+	capture.sequence++
+	path := filepath.Join(capture.dir, fmt.Sprintf("wintun-%s-%04d.pcap", time.Now().Format("20060102-150405"), capture.sequence))
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	var header [pcapGlobalHeaderSize]byte
+	binary.LittleEndian.PutUint32(header[0:], 0xa1b2c3d4)
+	binary.LittleEndian.PutUint16(header[4:], 2)
+	binary.LittleEndian.PutUint16(header[6:], 4)
+	binary.LittleEndian.PutUint32(header[16:], PacketSizeMax)
+	binary.LittleEndian.PutUint32(header[20:], pcapLinkTypeRaw)
+	capture.file = file
+	capture.writer = bufio.NewWriter(file)
+	capture.writer.Write(header[:])
+	capture.size = pcapGlobalHeaderSize
+	capture.files = append(capture.files, path)
+	for len(capture.files) > capture.maxFiles {
+		os.Remove(capture.files[0])
+		capture.files = capture.files[1:]
+	}
+	return nil
+}
+
+func (capture *rotatingCapture) close() {
+	if capture.file == nil {
+		return
+	}
+	capture.writer.Flush()
+	capture.file.Close()
+	capture.file = nil
+}
+
+// fail stops the capture after an error, so that a full disk does not cost a
+// failing write per packet.
+func (capture *rotatingCapture) fail(err error) {
+	log.Printf("Wintun capture stopped: %v%s", err, capture.adapter.logTags())
+	capture.failed = true
+	capture.close()
+}
@@ -0,0 +1,192 @@
+//go:build windows
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package wintun
+
+import (
+	"encoding/binary"
+)
+
+const (
+	protocolICMP   = 1
+	protocolTCP    = 6
+	protocolUDP    = 17
+	protocolICMPv6 = 58
+)
+
+// checksumAdd adds data to the running one's complement sum and returns the
+// new sum, unfolded.
+func checksumAdd(sum uint32, data []byte) uint32 {
+	for len(data) >= 2 {
+		sum += uint32(binary.BigEndian.Uint16(data))
+		data = data[2:]
+	}
+	if len(data) == 1 {
+		sum += uint32(data[0]) << 8
+	}
+	return sum
+}
+
+func checksumFinish(sum uint32) uint16 {
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+	return ^uint16(sum)
+}
+
+// IPv4Checksum returns the header checksum of the IPv4 header at the start of
+// hdr, computed as if its checksum field were zero. It returns 0 if hdr is too
+// short for the header length it declares.
+func IPv4Checksum(hdr []byte) uint16 {
+	if len(hdr) < 20 {
+		return 0
+	}
+	headerLen := int(hdr[0]&0xf) * 4
+	if headerLen < 20 || len(hdr) < headerLen {
+		return 0
+	}
+	sum := checksumAdd(0, hdr[:10])
+	sum = checksumAdd(sum, hdr[12:headerLen])
+	return checksumFinish(sum)
+}
+
+// transportPayload returns the protocol and the offset of the transport
+// header of an IPv4 or IPv6 packet, skipping IPv6 extension headers. It returns
+// false for malformed packets and for fragments, whose transport header cannot
+// be checked on its own.
+func transportPayload(pkt []byte) (protocol uint8, offset int, ok bool) {
+	return ipPayload(pkt, false)
+}
+
+// ipPayload returns the protocol and the offset of the payload of an IPv4 or
+// IPv6 packet, after the IPv4 header or the IPv6 hop-by-hop options, routing,
+// fragment and destination options extension headers. It returns false for
+// malformed packets and, unless fragments is set, for fragments. The protocol
+// of a fragment is known even for later fragments, but its payload then does
+// not start with the transport header.
+func ipPayload(pkt []byte, fragments bool) (protocol uint8, offset int, ok bool) {
+	if len(pkt) < 1 {
+		return
+	}
+	switch pkt[0] >> 4 {
+	case 4:
+		if len(pkt) < 20 {
+			return
+		}
+		offset = int(pkt[0]&0xf) * 4
+		if offset < 20 || len(pkt) < offset {
+			return
+		}
+		if !fragments && binary.BigEndian.Uint16(pkt[6:8])&0x3fff != 0 {
+			return
+		}
+		return pkt[9], offset, true
+	case 6:
+		if len(pkt) < 40 {
+			return
+		}
+		protocol, offset = pkt[6], 40
+		for {
+			switch protocol {
+			case 0, 43, 60: // Hop-by-hop, routing and destination options
+				if len(pkt) < offset+8 {
+					return
+				}
+				protocol, offset = pkt[offset], offset+int(pkt[offset+1])*8+8
+			case 44: // Fragment
+				if !fragments || len(pkt) < offset+8 {
+					return
+				}
+				protocol, offset = pkt[offset], offset+8
+			default:
+				return protocol, offset, offset <= len(pkt)
+			}
+		}
+	}
+	return
+}
+
+// transportChecksum computes the checksum of the transport segment of pkt at
+// offset, including the pseudo-header for IPv4 and IPv6, with the checksum
+// field at checksumOffset within the segment treated as zero.
+func transportChecksum(pkt []byte, protocol uint8, offset int, checksumOffset int) uint16 {
+	segment := pkt[offset:]
+	var sum uint32
+	if protocol != protocolICMP { // ICMP for IPv4 has no pseudo-header
+		if pkt[0]>>4 == 4 {
+			sum = checksumAdd(sum, pkt[12:20])
+		} else {
+			sum = checksumAdd(sum, pkt[8:40])
+		}
+		sum += uint32(protocol)
+		sum += uint32(len(segment)>>16) + uint32(len(segment)&0xffff)
+	}
+	sum = checksumAdd(sum, segment[:checksumOffset])
+	sum = checksumAdd(sum, segment[checksumOffset+2:])
+	return checksumFinish(sum)
+}
+
+// TCPChecksum returns the checksum of the TCP segment carried by the IPv4 or
+// IPv6 packet pkt, computed as if its checksum field were zero. It returns 0 if
+// pkt does not carry a complete, unfragmented TCP header.
+func TCPChecksum(pkt []byte) uint16 {
+	protocol, offset, ok := transportPayload(pkt)
+	if !ok || protocol != protocolTCP || len(pkt) < offset+20 {
+		return 0
+	}
+	return transportChecksum(pkt, protocol, offset, 16)
+}
+
+// UDPChecksum returns the checksum of the UDP datagram carried by the IPv4 or
+// IPv6 packet pkt, computed as if its checksum field were zero. A computed
+// checksum of zero is returned as 0xffff, as zero means no checksum. It returns
+// 0 if pkt does not carry a complete, unfragmented UDP header.
+func UDPChecksum(pkt []byte) uint16 {
+	protocol, offset, ok := transportPayload(pkt)
+	if !ok || protocol != protocolUDP || len(pkt) < offset+8 {
+		return 0
+	}
+	checksum := transportChecksum(pkt, protocol, offset, 6)
+	if checksum == 0 {
+		checksum = 0xffff
+	}
+	return checksum
+}
+
+// FixChecksums recomputes and stores, in place, the IPv4 header checksum and
+// the TCP, UDP, ICMP or ICMPv6 checksum of pkt. Transport checksums of
+// fragments are left alone, since they cover data from other fragments.
+func FixChecksums(pkt []byte) {
+	if len(pkt) >= 20 && pkt[0]>>4 == 4 {
+		if headerLen := int(pkt[0]&0xf) * 4; headerLen >= 20 && len(pkt) >= headerLen {
+			binary.BigEndian.PutUint16(pkt[10:12], IPv4Checksum(pkt))
+		}
+	}
+	protocol, offset, ok := transportPayload(pkt)
+	if !ok {
+		return
+	}
+	var checksumOffset, headerLen int
+	switch protocol {
+	case protocolTCP:
+		checksumOffset, headerLen = 16, 20
+	case protocolUDP:
+		checksumOffset, headerLen = 6, 8
+	case protocolICMP, protocolICMPv6:
+		checksumOffset, headerLen = 2, 4
+	default:
+		return
+	}
+	if len(pkt) < offset+headerLen {
+		return
+	}
+	checksum := transportChecksum(pkt, protocol, offset, checksumOffset)
+	if protocol == protocolUDP && checksum == 0 {
+		checksum = 0xffff
+	}
+	binary.BigEndian.PutUint16(pkt[offset+checksumOffset:], checksum)
+}
@@ -0,0 +1,126 @@
+//go:build windows
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package wintun
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"testing"
+)
+
+// Packets with correct checksums, between 10.0.0.1 and 10.0.0.2 for IPv4 and
+// fd00::1 and fd00::2 for IPv6.
+const (
+	packetIPv4UDPOptions = "4600002d1c464000401175730a0000010a0000029404000014e900350015264a68656c6c6f2c2077696e74756e"
+	packetIPv4TCP        = "4500003a1c46400040060a760a0000010a000002c000005000000001000000005018fffffcc50000474554202f20485454502f312e310d0a0d0a"
+	packetIPv4ICMP       = "450000201c46400040010a950a0000010a000002080006fa1234000170696e67"
+	packetIPv4UDPZero    = "450000221c46400040110a830a0000010a00000203e807d0000effff7a65726ff342"
+	packetIPv6UDPExt     = "6000000000250040fd000000000000000000000000000001fd0000000000000000000000000000023c00010400000000110001040000000014e900350015404868656c6c6f2c2077696e74756e"
+	packetIPv6ICMPv6     = "60000000000c3a40fd000000000000000000000000000001fd000000000000000000000000000002800094ae1234000170696e67"
+	packetIPv4Fragment   = "4500002a1c46200040112a7b0a0000010a00000214e900350016e5f0666972737420667261676d656e74"
+	packetIPv6Fragment   = "60000000001d2c40fd000000000000000000000000000001fd000000000000000000000000000002110000010000002a14e900350015404868656c6c6f2c2077696e74756e"
+)
+
+func decodePacket(t *testing.T, s string) []byte {
+	t.Helper()
+	pkt, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pkt
+}
+
+func TestIPv4Checksum(t *testing.T) {
+	hdr := decodePacket(t, "45000073000040004011b861c0a80001c0a800c7")
+	if got := IPv4Checksum(hdr); got != 0xb861 {
+		t.Errorf("IPv4Checksum = %#04x, want 0xb861", got)
+	}
+	if got := IPv4Checksum(hdr[:19]); got != 0 {
+		t.Errorf("IPv4Checksum of a truncated header = %#04x, want 0", got)
+	}
+}
+
+func TestFixChecksums(t *testing.T) {
+	tests := []struct {
+		name      string
+		packet    string
+		checksums []int // Offsets of the checksum fields
+	}{
+		{"IPv4 UDP with options", packetIPv4UDPOptions, []int{10, 30}},
+		{"IPv4 TCP", packetIPv4TCP, []int{10, 36}},
+		{"IPv4 ICMP", packetIPv4ICMP, []int{10, 22}},
+		{"IPv4 UDP summing to zero", packetIPv4UDPZero, []int{10, 26}},
+		{"IPv6 UDP with extension headers", packetIPv6UDPExt, []int{62}},
+		{"IPv6 ICMPv6", packetIPv6ICMPv6, []int{42}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			want := decodePacket(t, test.packet)
+			pkt := append([]byte(nil), want...)
+			for _, offset := range test.checksums {
+				binary.BigEndian.PutUint16(pkt[offset:], 0xdead)
+			}
+			FixChecksums(pkt)
+			if !bytes.Equal(pkt, want) {
+				t.Errorf("FixChecksums produced\n%x, want\n%x", pkt, want)
+			}
+		})
+	}
+}
+
+func TestFixChecksumsSkipsFragments(t *testing.T) {
+	tests := []struct {
+		name      string
+		packet    string
+		header    int // Offset of the IPv4 header checksum, or -1
+		transport int // Offset of the transport checksum
+	}{
+		{"IPv4", packetIPv4Fragment, 10, 26},
+		{"IPv6", packetIPv6Fragment, -1, 54},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			want := decodePacket(t, test.packet)
+			binary.BigEndian.PutUint16(want[test.transport:], 0xdead)
+			pkt := append([]byte(nil), want...)
+			if test.header >= 0 {
+				binary.BigEndian.PutUint16(pkt[test.header:], 0xdead)
+			}
+			FixChecksums(pkt)
+			if !bytes.Equal(pkt, want) {
+				t.Errorf("FixChecksums produced\n%x, want\n%x", pkt, want)
+			}
+			if got := UDPChecksum(pkt); got != 0 {
+				t.Errorf("UDPChecksum of a fragment = %#04x, want 0", got)
+			}
+		})
+	}
+}
+
+func TestTransportChecksums(t *testing.T) {
+	tests := []struct {
+		name     string
+		packet   string
+		checksum func([]byte) uint16
+		want     uint16
+	}{
+		{"TCP", packetIPv4TCP, TCPChecksum, 0xfcc5},
+		{"UDP", packetIPv6UDPExt, UDPChecksum, 0x4048},
+		{"UDP summing to zero", packetIPv4UDPZero, UDPChecksum, 0xffff},
+		{"TCP of a UDP packet", packetIPv4UDPOptions, TCPChecksum, 0},
+		{"UDP of a truncated packet", packetIPv4UDPOptions[:2*26], UDPChecksum, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.checksum(decodePacket(t, test.packet)); got != test.want {
+				t.Errorf("checksum = %#04x, want %#04x", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+//go:build windows
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package wintun
+
+import (
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// ipAdapterAddressesLH extends windows.IpAdapterAddresses, which stops at
+// FirstPrefix, with the IP_ADAPTER_ADDRESSES_LH fields up to CompartmentId.
+type ipAdapterAddressesLH struct {
+	windows.IpAdapterAddresses
+	TransmitLinkSpeed      uint64
+	ReceiveLinkSpeed       uint64
+	FirstWinsServerAddress uintptr
+	FirstGatewayAddress    uintptr
+	Ipv4Metric             uint32
+	Ipv6Metric             uint32
+	Luid                   uint64
+	Dhcpv4Server           windows.SocketAddress
+	CompartmentId          uint32
+}
+
+// Compartment returns the ID of the network compartment the adapter's
+// interface is in, 1 being the default compartment. Like all IP Helper
+// queries, the lookup only sees the interfaces of the calling thread's
+// compartment, so an interface in another compartment is reported as
+// ERROR_NOT_FOUND. It requires Windows Vista or later, where
+// IP_ADAPTER_ADDRESSES gained the CompartmentId field, and returns
+// ERROR_NOT_SUPPORTED otherwise; all versions Wintun supports qualify.
+// Windows offers no public API to move an interface to another compartment,
+// and the NSI parameters that would do so are undocumented, so there is no
+// corresponding setter; the interface stays in the compartment it was created
+// in.
+func (wintun *Adapter) Compartment() (uint32, error) {
+	const (
+		GAA_FLAG_SKIP_UNICAST    = 0x1
+		GAA_FLAG_SKIP_ANYCAST    = 0x2
+		GAA_FLAG_SKIP_MULTICAST  = 0x4
+		GAA_FLAG_SKIP_DNS_SERVER = 0x8
+	)
+	luid := wintun.LUID()
+	size := uint32(15000)
+	for {
+		buf := make([]byte, size)
+		err := windows.GetAdaptersAddresses(windows.AF_UNSPEC, GAA_FLAG_SKIP_UNICAST|GAA_FLAG_SKIP_ANYCAST|GAA_FLAG_SKIP_MULTICAST|GAA_FLAG_SKIP_DNS_SERVER, 0, (*windows.IpAdapterAddresses)(unsafe.Pointer(&buf[0])), &size)
+		if err == windows.ERROR_BUFFER_OVERFLOW {
+			continue
+		} else if err != nil {
+			return 0, err
+		}
+		for addresses := (*windows.IpAdapterAddresses)(unsafe.Pointer(&buf[0])); addresses != nil; addresses = addresses.Next {
+			if addresses.Length < uint32(unsafe.Sizeof(ipAdapterAddressesLH{})) {
+				return 0, windows.ERROR_NOT_SUPPORTED
+			}
+			row := (*ipAdapterAddressesLH)(unsafe.Pointer(addresses))
+			if row.Luid == luid {
+				return row.CompartmentId, nil
+			}
+		}
+		return 0, windows.ERROR_NOT_FOUND
+	}
+}
@@ -0,0 +1,245 @@
+//go:build windows
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package wintun
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+
+	"golang.org/x/sys/windows"
+)
+
+// DeviceConfig is the IP configuration of an adapter, as read by ExportConfig
+// and applied by ApplyConfig.
+type DeviceConfig struct {
+	Addresses     []netip.Prefix
+	Routes        []RouteEntry // Routes added with AddRoute, not the ones Windows derives from addresses
+	DNS           []netip.Addr
+	SearchDomains []string
+	MTU           uint32 // IPv4 MTU; IPv6 uses it too if it is at least 1280
+	Metric        uint32 // Interface metric, 0 for automatic
+}
+
+// ExportConfig reads back the addresses, routes, DNS settings, MTU and metric
+// of the adapter, so that ApplyConfig can reproduce them later, or on another
+// adapter. Only manually configured addresses are exported, not the IPv6
+// link-local and other addresses that Windows configures by itself, which
+// belong to the adapter they were generated for.
+func (wintun *Adapter) ExportConfig() (DeviceConfig, error) {
+	const (
+		MIB_IPPROTO_NETMGMT  = 3
+		IpPrefixOriginManual = 1
+	)
+	var config DeviceConfig
+	rows, err := wintun.addresses()
+	if err != nil {
+		return DeviceConfig{}, err
+	}
+	for i := range rows {
+		if rows[i].PrefixOrigin != IpPrefixOriginManual {
+			continue
+		}
+		config.Addresses = append(config.Addresses, netip.PrefixFrom(rows[i].Address.addr(), int(rows[i].OnLinkPrefixLength)))
+	}
+	table, err := getIPForwardTable2(windows.AF_UNSPEC)
+	if err != nil {
+		return DeviceConfig{}, err
+	}
+	luid := wintun.LUID()
+	for i := range table {
+		row := &table[i]
+		if row.InterfaceLUID != luid || row.Protocol != MIB_IPPROTO_NETMGMT {
+			continue
+		}
+		route := RouteEntry{
+			Destination: netip.PrefixFrom(row.DestinationPrefix.Prefix.addr(), int(row.DestinationPrefix.PrefixLength)),
+			Metric:      row.Metric,
+		}
+		if nextHop := row.NextHop.addr(); !nextHop.IsUnspecified() {
+			route.NextHop = nextHop
+		}
+		config.Routes = append(config.Routes, route)
+	}
+	config.DNS, config.SearchDomains, err = wintun.DNS()
+	if err != nil {
+		return DeviceConfig{}, err
+	}
+	row, err := wintun.ipInterface(windows.AF_INET)
+	if err != nil {
+		return DeviceConfig{}, err
+	}
+	config.MTU = row.NLMTU
+	if !row.UseAutomaticMetric {
+		config.Metric = row.Metric
+	}
+	return config, nil
+}
+
+// ApplyConfig applies config, as exported by ExportConfig, to the adapter.
+// Addresses and routes are added to the existing ones, and those that already
+// exist are kept; DNS settings replace the existing ones. A zero MTU leaves
+// the MTU unchanged.
+func (wintun *Adapter) ApplyConfig(config DeviceConfig) error {
+	if config.MTU != 0 && (config.MTU < 576 || config.MTU > PacketSizeMax) {
+		return windows.ERROR_INVALID_PARAMETER
+	}
+	for _, family := range []uint16{windows.AF_INET, windows.AF_INET6} {
+		row, err := wintun.ipInterface(family)
+		if err == windows.ERROR_NOT_FOUND && family == windows.AF_INET6 {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if config.MTU != 0 && (family == windows.AF_INET || config.MTU >= 1280) {
+			row.NLMTU = config.MTU
+		}
+		row.UseAutomaticMetric = config.Metric == 0
+		row.Metric = config.Metric
+		err = setIPInterface(row)
+		if err != nil {
+			return err
+		}
+	}
+	for _, address := range config.Addresses {
+		err := wintun.SetIPAddress(address)
+		if err != nil && err != windows.ERROR_OBJECT_ALREADY_EXISTS {
+			return err
+		}
+	}
+	for _, route := range config.Routes {
+		err := wintun.AddRoute(route)
+		if err != nil && err != windows.ERROR_OBJECT_ALREADY_EXISTS {
+			return err
+		}
+	}
+	return wintun.SetDNS(config.DNS, config.SearchDomains)
+}
+
+// ConfigDiffKind is the kind of a discrepancy reported by VerifyConfig.
+type ConfigDiffKind int
+
+const (
+	ConfigMissing  ConfigDiffKind = iota // Wanted but absent
+	ConfigExtra                          // Present but not wanted
+	ConfigMismatch                       // Present with another value
+)
+
+// ConfigDiff is a discrepancy between the wanted and the actual configuration.
+// Field names the DeviceConfig field, and Want and Got hold the values, empty
+// for the side on which the item is absent.
+type ConfigDiff struct {
+	Field string
+	Kind  ConfigDiffKind
+	Want  string
+	Got   string
+}
+
+func (diff ConfigDiff) String() string {
+	switch diff.Kind {
+	case ConfigMissing:
+		return fmt.Sprintf("%s: missing %s", diff.Field, diff.Want)
+	case ConfigExtra:
+		return fmt.Sprintf("%s: unexpected %s", diff.Field, diff.Got)
+	}
+	return fmt.Sprintf("%s: want %s, got %s", diff.Field, diff.Want, diff.Got)
+}
+
+// diffSets reports the items of want missing from got and the extra items of
+// got.
+func diffSets(field string, want, got []string) (diffs []ConfigDiff) {
+	present := make(map[string]bool, len(got))
+	for _, item := range got {
+		present[item] = true
+	}
+	wanted := make(map[string]bool, len(want))
+	for _, item := range want {
+		wanted[item] = true
+		if !present[item] {
+			diffs = append(diffs, ConfigDiff{Field: field, Kind: ConfigMissing, Want: item})
+		}
+	}
+	for _, item := range got {
+		if !wanted[item] {
+			diffs = append(diffs, ConfigDiff{Field: field, Kind: ConfigExtra, Got: item})
+		}
+	}
+	return
+}
+
+// stringsOf returns the strings of n items, as given by item.
+func stringsOf(n int, item func(i int) fmt.Stringer) []string {
+	strs := make([]string, n)
+	for i := range strs {
+		strs[i] = item(i).String()
+	}
+	return strs
+}
+
+func (config *DeviceConfig) addressStrings() []string {
+	return stringsOf(len(config.Addresses), func(i int) fmt.Stringer { return config.Addresses[i] })
+}
+
+func (config *DeviceConfig) routeStrings() []string {
+	return stringsOf(len(config.Routes), func(i int) fmt.Stringer { return config.Routes[i] })
+}
+
+// dnsString lists the IPv4 DNS servers of config before the IPv6 ones, each
+// family in its own order, as SetDNS stores the families separately and DNS
+// reads them back in that order.
+func (config *DeviceConfig) dnsString() string {
+	var servers4, servers6 []string
+	for _, server := range config.DNS {
+		if server = server.Unmap(); server.Is4() {
+			servers4 = append(servers4, server.String())
+		} else {
+			servers6 = append(servers6, server.String())
+		}
+	}
+	return strings.Join(append(servers4, servers6...), ",")
+}
+
+func (route RouteEntry) String() string {
+	if !route.NextHop.IsValid() {
+		return fmt.Sprintf("%v on-link metric %d", route.Destination.Masked(), route.Metric)
+	}
+	return fmt.Sprintf("%v via %v metric %d", route.Destination.Masked(), route.NextHop, route.Metric)
+}
+
+// VerifyConfig reads back the adapter's configuration, as ExportConfig does,
+// and returns how it differs from want, so that health checks and
+// reconciliation loops can detect settings that were reverted or changed
+// behind their back. Addresses and routes are compared as sets, DNS servers
+// as ordered lists within each address family, and search domains as an
+// ordered list. Only the manually configured addresses
+// of the adapter are compared, as ExportConfig exports them, so that the IPv6
+// link-local address Windows assigns is not reported as extra. A zero MTU in
+// want is not checked. An empty result means the adapter is configured as
+// wanted.
+func (wintun *Adapter) VerifyConfig(want DeviceConfig) ([]ConfigDiff, error) {
+	got, err := wintun.ExportConfig()
+	if err != nil {
+		return nil, err
+	}
+	var diffs []ConfigDiff
+	diffs = append(diffs, diffSets("Addresses", want.addressStrings(), got.addressStrings())...)
+	diffs = append(diffs, diffSets("Routes", want.routeStrings(), got.routeStrings())...)
+	if wantDNS, gotDNS := want.dnsString(), got.dnsString(); wantDNS != gotDNS {
+		diffs = append(diffs, ConfigDiff{Field: "DNS", Kind: ConfigMismatch, Want: wantDNS, Got: gotDNS})
+	}
+	if wantDomains, gotDomains := strings.Join(want.SearchDomains, ","), strings.Join(got.SearchDomains, ","); wantDomains != gotDomains {
+		diffs = append(diffs, ConfigDiff{Field: "SearchDomains", Kind: ConfigMismatch, Want: wantDomains, Got: gotDomains})
+	}
+	if want.MTU != 0 && want.MTU != got.MTU {
+		diffs = append(diffs, ConfigDiff{Field: "MTU", Kind: ConfigMismatch, Want: fmt.Sprint(want.MTU), Got: fmt.Sprint(got.MTU)})
+	}
+	if want.Metric != got.Metric {
+		diffs = append(diffs, ConfigDiff{Field: "Metric", Kind: ConfigMismatch, Want: fmt.Sprint(want.Metric), Got: fmt.Sprint(got.Metric)})
+	}
+	return diffs, nil
+}
@@ -0,0 +1,61 @@
+//go:build windows
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package wintun
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestVerifyConfigIgnoresAutoconfigured(t *testing.T) {
+	wintun := createTestAdapter(t, testAdapterName, nil)
+	session, err := wintun.StartSession(RingCapacityMin)
+	if err != nil {
+		t.Fatalf("StartSession failed: %v", err)
+	}
+	defer session.End()
+	want := DeviceConfig{Addresses: []netip.Prefix{netip.MustParsePrefix("198.18.0.1/24")}}
+	if err := wintun.ApplyConfig(want); err != nil {
+		t.Fatalf("ApplyConfig failed: %v", err)
+	}
+	diffs, err := wintun.VerifyConfig(want)
+	if err != nil {
+		t.Fatalf("VerifyConfig failed: %v", err)
+	}
+	if len(diffs) != 0 {
+		t.Errorf("VerifyConfig reported %v, want no differences", diffs)
+	}
+}
+
+func TestVerifyConfigMixedFamilyDNS(t *testing.T) {
+	wintun := createTestAdapter(t, testAdapterName, nil)
+	want := DeviceConfig{DNS: []netip.Addr{
+		netip.MustParseAddr("2001:db8::53"),
+		netip.MustParseAddr("198.18.0.53"),
+		netip.MustParseAddr("2001:db8::54"),
+		netip.MustParseAddr("198.18.0.54"),
+	}}
+	if err := wintun.ApplyConfig(want); err != nil {
+		t.Fatalf("ApplyConfig failed: %v", err)
+	}
+	diffs, err := wintun.VerifyConfig(want)
+	if err != nil {
+		t.Fatalf("VerifyConfig failed: %v", err)
+	}
+	if len(diffs) != 0 {
+		t.Errorf("VerifyConfig reported %v, want no differences", diffs)
+	}
+	want.DNS[0], want.DNS[2] = want.DNS[2], want.DNS[0]
+	diffs, err = wintun.VerifyConfig(want)
+	if err != nil {
+		t.Fatalf("VerifyConfig failed: %v", err)
+	}
+	if len(diffs) != 1 || diffs[0].Field != "DNS" {
+		t.Errorf("VerifyConfig reported %v, want a DNS mismatch", diffs)
+	}
+}
@@ -8,7 +8,12 @@
 package wintun
 
 import (
+	"debug/pe"
+	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
 	"sync"
 	"sync/atomic"
 	"unsafe"
@@ -67,6 +72,8 @@ type lazyDLL struct {
 	mu     sync.Mutex
 	module windows.Handle
 	onLoad func(d *lazyDLL)
+	verify func(path string) error
+	err    *LoadError // Why the last load failed, guarded by mu
 }
 
 func (d *lazyDLL) Load() error {
@@ -83,10 +90,46 @@ func (d *lazyDLL) Load() error {
 		LOAD_LIBRARY_SEARCH_APPLICATION_DIR = 0x00000200
 		LOAD_LIBRARY_SEARCH_SYSTEM32        = 0x00000800
 	)
-	module, err := windows.LoadLibraryEx(d.Name, 0, LOAD_LIBRARY_SEARCH_APPLICATION_DIR|LOAD_LIBRARY_SEARCH_SYSTEM32)
+	name := d.Name
+	var verified windows.Handle
+	if d.verify != nil {
+		path, err := d.searchPath()
+		if err != nil {
+			d.err = &LoadError{d.Name, LoadErrorNotFound, err}
+			return d.err
+		}
+		// Keep the file open, denying writes, deletion and renaming, from
+		// the verification until it is loaded, so that it cannot be
+		// replaced in between.
+		verified, err = openFileDenyWrite(path)
+		if err != nil {
+			d.err = d.loadError(path, err)
+			return d.err
+		}
+		defer windows.CloseHandle(verified)
+		err = d.verify(path)
+		if err != nil {
+			d.err = &LoadError{path, LoadErrorSignature, err}
+			return d.err
+		}
+		name = path
+	}
+	module, err := windows.LoadLibraryEx(name, 0, LOAD_LIBRARY_SEARCH_APPLICATION_DIR|LOAD_LIBRARY_SEARCH_SYSTEM32)
 	if err != nil {
-		return fmt.Errorf("Unable to load library: %w", err)
+		d.err = d.loadError(name, err)
+		return d.err
+	}
+	if verified != 0 {
+		// The path could still lead elsewhere if a directory on it was
+		// swapped, so check that the module is the file that was verified.
+		err = moduleIsFile(module, verified)
+		if err != nil {
+			windows.FreeLibrary(module)
+			d.err = &LoadError{name, LoadErrorSignature, err}
+			return d.err
+		}
 	}
+	d.err = nil
 
 	atomic.StorePointer((*unsafe.Pointer)(unsafe.Pointer(&d.module)), unsafe.Pointer(module))
 	if d.onLoad != nil {
@@ -95,10 +138,204 @@ func (d *lazyDLL) Load() error {
 	return nil
 }
 
+func openFileDenyWrite(path string) (windows.Handle, error) {
+	path16, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, err
+	}
+	return windows.CreateFile(path16, windows.GENERIC_READ, windows.FILE_SHARE_READ, nil, windows.OPEN_EXISTING, 0, 0)
+}
+
+// errModuleNotVerified is returned when the module loaded is not the file whose
+// signature was verified.
+var errModuleNotVerified = errors.New("Loaded module is not the file that was verified")
+
+// moduleIsFile returns errModuleNotVerified unless module was loaded from the
+// open file.
+func moduleIsFile(module windows.Handle, file windows.Handle) error {
+	var buf [windows.MAX_LONG_PATH]uint16
+	_, err := windows.GetModuleFileName(module, &buf[0], uint32(len(buf)))
+	if err != nil {
+		return err
+	}
+	loaded, err := windows.CreateFile(&buf[0], 0, windows.FILE_SHARE_READ|windows.FILE_SHARE_WRITE|windows.FILE_SHARE_DELETE, nil, windows.OPEN_EXISTING, 0, 0)
+	if err != nil {
+		return err
+	}
+	defer windows.CloseHandle(loaded)
+	var want, got windows.ByHandleFileInformation
+	err = windows.GetFileInformationByHandle(file, &want)
+	if err != nil {
+		return err
+	}
+	err = windows.GetFileInformationByHandle(loaded, &got)
+	if err != nil {
+		return err
+	}
+	if want.VolumeSerialNumber != got.VolumeSerialNumber || want.FileIndexHigh != got.FileIndexHigh || want.FileIndexLow != got.FileIndexLow {
+		return errModuleNotVerified
+	}
+	return nil
+}
+
+// LoadErrorReason classifies why the Wintun DLL failed to load.
+type LoadErrorReason int
+
+const (
+	LoadErrorOther        LoadErrorReason = iota
+	LoadErrorNotFound                     // No wintun.dll next to the executable or in System32
+	LoadErrorAccessDenied                 // The file could not be opened
+	LoadErrorBadImage                     // The file is not a valid DLL
+	LoadErrorArchMismatch                 // The DLL is built for another architecture than the process
+	LoadErrorSignature                    // The signature check set by RequireDLLSignature failed
+)
+
+func (reason LoadErrorReason) String() string {
+	switch reason {
+	case LoadErrorNotFound:
+		return "not found"
+	case LoadErrorAccessDenied:
+		return "access denied"
+	case LoadErrorBadImage:
+		return "bad image"
+	case LoadErrorArchMismatch:
+		return "architecture mismatch"
+	case LoadErrorSignature:
+		return "signature verification failed"
+	}
+	return "other"
+}
+
+// LoadError describes a failure to load the Wintun DLL.
+type LoadError struct {
+	Path   string // The file that failed to load, or the DLL name if it was not found
+	Reason LoadErrorReason
+	Err    error
+}
+
+func (e *LoadError) Error() string {
+	return fmt.Sprintf("Unable to load library %s: %s: %v", e.Path, e.Reason, e.Err)
+}
+
+func (e *LoadError) Unwrap() error {
+	return e.Err
+}
+
+// loadError classifies an error that LoadLibraryEx returned for name.
+func (d *lazyDLL) loadError(name string, err error) *LoadError {
+	e := &LoadError{name, LoadErrorOther, err}
+	switch err {
+	case windows.ERROR_MOD_NOT_FOUND, windows.ERROR_FILE_NOT_FOUND, windows.ERROR_PATH_NOT_FOUND:
+		e.Reason = LoadErrorNotFound
+	case windows.ERROR_ACCESS_DENIED:
+		e.Reason = LoadErrorAccessDenied
+	case windows.ERROR_INVALID_IMAGE_HASH:
+		e.Reason = LoadErrorSignature
+	case windows.ERROR_EXE_MACHINE_TYPE_MISMATCH:
+		e.Reason = LoadErrorArchMismatch
+	case windows.ERROR_BAD_EXE_FORMAT, windows.ERROR_BAD_FORMAT:
+		// Windows reports a DLL for another architecture like a corrupt one,
+		// so tell them apart by the machine type of the file.
+		e.Reason = LoadErrorBadImage
+		if path, err := d.searchPath(); err == nil {
+			e.Path = path
+			if file, err := pe.Open(path); err == nil {
+				if machine, ok := peMachines[runtime.GOARCH]; ok && file.Machine != machine {
+					e.Reason = LoadErrorArchMismatch
+				}
+				file.Close()
+			}
+		}
+	}
+	return e
+}
+
+var peMachines = map[string]uint16{
+	"386":   pe.IMAGE_FILE_MACHINE_I386,
+	"amd64": pe.IMAGE_FILE_MACHINE_AMD64,
+	"arm":   pe.IMAGE_FILE_MACHINE_ARMNT,
+	"arm64": pe.IMAGE_FILE_MACHINE_ARM64,
+}
+
+// LastLoadError returns why the last attempt to load the Wintun DLL failed, as
+// a *LoadError, or nil if it is loaded or no attempt has been made yet. Load
+// failures otherwise only surface, in less detail, from the first call into
+// the DLL.
+func LastLoadError() error {
+	modwintun.mu.Lock()
+	defer modwintun.mu.Unlock()
+	if modwintun.err == nil {
+		return nil
+	}
+	return modwintun.err
+}
+
+// path returns the file the DLL was loaded from or, if it has not been loaded
+// yet, the file it would be loaded from.
+func (d *lazyDLL) path() (string, error) {
+	if module := windows.Handle(atomic.LoadPointer((*unsafe.Pointer)(unsafe.Pointer(&d.module)))); module != 0 {
+		var buf [windows.MAX_LONG_PATH]uint16
+		n, err := windows.GetModuleFileName(module, &buf[0], uint32(len(buf)))
+		if err != nil {
+			return "", err
+		}
+		return windows.UTF16ToString(buf[:n]), nil
+	}
+	return d.searchPath()
+}
+
+// searchPath mirrors the search order Load uses: the application directory,
+// then System32.
+func (d *lazyDLL) searchPath() (string, error) {
+	exe, err := os.Executable()
+	if err == nil {
+		path := filepath.Join(filepath.Dir(exe), d.Name)
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+	}
+	system32, err := windows.GetSystemDirectory()
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(system32, d.Name)
+	if _, err := os.Stat(path); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
 func (p *lazyProc) nameToAddr() (uintptr, error) {
 	return windows.GetProcAddress(p.dll.module, p.Name)
 }
 
+// DLLPath returns the full path of the file wintun.dll was loaded from, or, if
+// it has not been loaded yet, of the file it would be loaded from, to help
+// diagnose a wrong or planted copy being used.
+func DLLPath() (string, error) {
+	return modwintun.path()
+}
+
+// PinDLL loads wintun.dll, if it is not loaded yet, and pins it in memory until
+// the process exits, so that it stays loaded even if another component of the
+// process frees the library.
+func PinDLL() error {
+	err := modwintun.Load()
+	if err != nil {
+		return err
+	}
+	path, err := modwintun.path()
+	if err != nil {
+		return err
+	}
+	path16, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return err
+	}
+	var module windows.Handle
+	return windows.GetModuleHandleEx(windows.GET_MODULE_HANDLE_EX_FLAG_PIN, path16, &module)
+}
+
 // Version returns the version of the Wintun DLL.
 func Version() string {
 	if modwintun.Load() != nil {
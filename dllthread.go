@@ -0,0 +1,76 @@
+//go:build windows
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package wintun
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"unsafe"
+)
+
+type dllCall struct {
+	addr      uintptr
+	nargs     int
+	args      [3]uintptr
+	keepAlive []unsafe.Pointer
+	r1, r2    uintptr
+	err       syscall.Errno
+	done      chan struct{}
+}
+
+var (
+	serializedCalls int32 // accessed atomically
+	dllThreadOnce   sync.Once
+	dllThreadCalls  chan *dllCall
+)
+
+// SetSerializedCalls sets whether all calls into wintun.dll are made from a
+// single dedicated OS thread, rather than from whichever thread the calling
+// goroutine happens to run on. This is meant for ruling out threading issues
+// while debugging, and is off by default: every call, including the per-packet
+// ones, then costs two goroutine handoffs, which considerably lowers
+// throughput.
+func SetSerializedCalls(enabled bool) {
+	if !enabled {
+		atomic.StoreInt32(&serializedCalls, 0)
+		return
+	}
+	dllThreadOnce.Do(func() {
+		dllThreadCalls = make(chan *dllCall)
+		go runDLLThread(dllThreadCalls)
+	})
+	atomic.StoreInt32(&serializedCalls, 1)
+}
+
+func runDLLThread(calls <-chan *dllCall) {
+	runtime.LockOSThread()
+	for call := range calls {
+		call.r1, call.r2, call.err = syscall.SyscallN(call.addr, call.args[:call.nargs]...)
+		close(call.done)
+	}
+}
+
+// callProc calls proc with up to three arguments, on the dedicated thread if
+// SetSerializedCalls is enabled. Arguments pointing to Go memory must also be
+// passed in keepAlive, which moves them off the goroutine stack and keeps them
+// alive until the call returns.
+func callProc(proc *lazyProc, keepAlive []unsafe.Pointer, args ...uintptr) (r1, r2 uintptr, err syscall.Errno) {
+	addr := proc.Addr()
+	if atomic.LoadInt32(&serializedCalls) == 0 {
+		r1, r2, err = syscall.SyscallN(addr, args...)
+		runtime.KeepAlive(keepAlive)
+		return
+	}
+	call := &dllCall{addr: addr, nargs: len(args), keepAlive: keepAlive, done: make(chan struct{})}
+	copy(call.args[:], args)
+	dllThreadCalls <- call
+	<-call.done
+	return call.r1, call.r2, call.err
+}
@@ -0,0 +1,295 @@
+//go:build windows
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package wintun
+
+import (
+	"errors"
+	"log"
+	"net/netip"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/registry"
+)
+
+var (
+	moddnsapi                 = windows.NewLazySystemDLL("dnsapi.dll")
+	procDnsFlushResolverCache = moddnsapi.NewProc("DnsFlushResolverCache")
+)
+
+// FlushDNSCache clears the system DNS resolver cache, so that changes to DNS
+// servers take effect for names that were already resolved, like
+// ipconfig /flushdns does.
+func FlushDNSCache() (err error) {
+	r1, _, e1 := syscall.Syscall(procDnsFlushResolverCache.Addr(), 0, 0, 0, 0)
+	if r1 == 0 {
+		err = e1
+	}
+	return
+}
+
+// tcpipInterfaceKey opens the adapter's key under
+// HKLM\SYSTEM\CurrentControlSet\Services\Tcpip\Parameters\Interfaces.
+func (wintun *Adapter) tcpipInterfaceKey(access uint32) (registry.Key, error) {
+	return wintun.tcpipServiceInterfaceKey("Tcpip", access)
+}
+
+// tcpipServiceInterfaceKey opens the adapter's interface key of the Tcpip or
+// Tcpip6 service.
+func (wintun *Adapter) tcpipServiceInterfaceKey(service string, access uint32) (registry.Key, error) {
+	guid, err := wintun.guid()
+	if err != nil {
+		return 0, err
+	}
+	return registry.OpenKey(registry.LOCAL_MACHINE, `SYSTEM\CurrentControlSet\Services\`+service+`\Parameters\Interfaces\`+guid.String(), access)
+}
+
+// SetDNS sets the DNS servers and search domains of the adapter, replacing
+// the previous ones. IPv4 and IPv6 servers are written to the NameServer values
+// of the adapter's Tcpip and Tcpip6 interface keys, and the search domains to
+// the SearchList value of its Tcpip interface key, after which the resolver
+// cache is flushed. Empty lists clear the settings.
+func (wintun *Adapter) SetDNS(servers []netip.Addr, searchDomains []string) error {
+	var servers4, servers6 []string
+	for _, server := range servers {
+		server = server.Unmap()
+		switch {
+		case server.Is4():
+			servers4 = append(servers4, server.String())
+		case server.Is6():
+			servers6 = append(servers6, server.String())
+		default:
+			return windows.ERROR_INVALID_PARAMETER
+		}
+	}
+	if skipDryRun("set DNS servers %v and search domains %v on interface %d", servers, searchDomains, wintun.LUID()) {
+		return nil
+	}
+	key, err := wintun.tcpipInterfaceKey(registry.SET_VALUE)
+	if err != nil {
+		return err
+	}
+	defer key.Close()
+	err = key.SetStringValue("NameServer", strings.Join(servers4, ","))
+	if err != nil {
+		return err
+	}
+	err = key.SetStringValue("SearchList", strings.Join(searchDomains, ","))
+	if err != nil {
+		return err
+	}
+	key6, err := wintun.tcpipServiceInterfaceKey("Tcpip6", registry.SET_VALUE)
+	if err == nil {
+		defer key6.Close()
+		err = key6.SetStringValue("NameServer", strings.Join(servers6, ","))
+		if err != nil {
+			return err
+		}
+	} else if err != registry.ErrNotExist || len(servers6) != 0 {
+		// A missing key only means that IPv6 is not bound to the adapter.
+		return err
+	}
+	return FlushDNSCache()
+}
+
+// splitRegistryList splits a comma or space separated registry list.
+func splitRegistryList(value string) []string {
+	return strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ' ' })
+}
+
+// DNS returns the DNS servers and search domains of the adapter, as set by
+// SetDNS.
+func (wintun *Adapter) DNS() (servers []netip.Addr, searchDomains []string, err error) {
+	for _, service := range []string{"Tcpip", "Tcpip6"} {
+		key, err := wintun.tcpipServiceInterfaceKey(service, registry.QUERY_VALUE)
+		if err == registry.ErrNotExist && service == "Tcpip6" {
+			continue
+		} else if err != nil {
+			return nil, nil, err
+		}
+		value, _, err := key.GetStringValue("NameServer")
+		if err == nil {
+			for _, field := range splitRegistryList(value) {
+				server, err := netip.ParseAddr(field)
+				if err == nil {
+					servers = append(servers, server)
+				}
+			}
+		} else if err != registry.ErrNotExist {
+			key.Close()
+			return nil, nil, err
+		}
+		if service == "Tcpip" {
+			value, _, err = key.GetStringValue("SearchList")
+			if err == nil {
+				searchDomains = splitRegistryList(value)
+			} else if err != registry.ErrNotExist {
+				key.Close()
+				return nil, nil, err
+			}
+		}
+		key.Close()
+	}
+	return servers, searchDomains, nil
+}
+
+// SetDNSAtomic is like SetDNS, but first captures the current settings, and
+// returns a rollback function that restores them. If applying the new settings
+// fails, the previous ones are restored before returning the error. The
+// rollback function lives in memory only; to restore the settings after a crash,
+// persist what DNS returns before calling SetDNSAtomic, and pass it to SetDNS.
+func (wintun *Adapter) SetDNSAtomic(servers []netip.Addr, searchDomains []string) (rollback func(), err error) {
+	previousServers, previousSearchDomains, err := wintun.DNS()
+	if err != nil {
+		return nil, err
+	}
+	rollback = func() {
+		if err := wintun.SetDNS(previousServers, previousSearchDomains); err != nil {
+			log.Printf("Unable to restore DNS settings: %v", err)
+		}
+	}
+	err = wintun.SetDNS(servers, searchDomains)
+	if err != nil {
+		rollback()
+		return nil, err
+	}
+	return rollback, nil
+}
+
+// SetRegisterInDNS sets whether the adapter's addresses are registered in DNS
+// by the dynamic DNS client. It writes the RegistrationEnabled and
+// RegisterAdapterName values under the adapter's Tcpip interface key.
+func (wintun *Adapter) SetRegisterInDNS(register bool) error {
+	key, err := wintun.tcpipInterfaceKey(registry.SET_VALUE)
+	if err != nil {
+		return err
+	}
+	defer key.Close()
+	var value uint32
+	if register {
+		value = 1
+	}
+	err = key.SetDWordValue("RegistrationEnabled", value)
+	if err != nil {
+		return err
+	}
+	return key.SetDWordValue("RegisterAdapterName", value)
+}
+
+// RegisterInDNS reports whether the adapter's addresses are registered in DNS,
+// as set by SetRegisterInDNS. Windows registers addresses when the
+// RegistrationEnabled value is absent.
+func (wintun *Adapter) RegisterInDNS() (bool, error) {
+	key, err := wintun.tcpipInterfaceKey(registry.QUERY_VALUE)
+	if err != nil {
+		return false, err
+	}
+	defer key.Close()
+	value, _, err := key.GetIntegerValue("RegistrationEnabled")
+	if err == registry.ErrNotExist {
+		return true, nil
+	} else if err != nil {
+		return false, err
+	}
+	return value != 0, nil
+}
+
+// ErrUnsupportedWindowsVersion is returned by features that the running
+// version of Windows does not provide.
+var ErrUnsupportedWindowsVersion = errors.New("Feature not supported on this version of Windows")
+
+const (
+	dohFlagEnableAuto     = 0x1 // DNS_DOH_SERVER_SETTINGS_ENABLE_AUTO
+	dohFlagEnable         = 0x2 // DNS_DOH_SERVER_SETTINGS_ENABLE
+	dohFlagFallbackToUDP  = 0x4 // DNS_DOH_SERVER_SETTINGS_FALLBACK_TO_UDP
+	dohMinimumBuildNumber = 22000
+)
+
+// dohServerKeyPath returns the path of the per-interface DNS over HTTPS key for
+// server, under HKLM\SYSTEM\CurrentControlSet\Services\Dnscache.
+func (wintun *Adapter) dohServerKeyPath(server netip.Addr) (string, error) {
+	if windows.RtlGetVersion().BuildNumber < dohMinimumBuildNumber {
+		return "", ErrUnsupportedWindowsVersion
+	}
+	server = server.Unmap()
+	if !server.IsValid() {
+		return "", windows.ERROR_INVALID_PARAMETER
+	}
+	guid, err := wintun.guid()
+	if err != nil {
+		return "", err
+	}
+	family := "Doh"
+	if server.Is6() {
+		family = "Doh6"
+	}
+	return `SYSTEM\CurrentControlSet\Services\Dnscache\InterfaceSpecificParameters\` + guid.String() + `\DohInterfaceSettings\` + family + `\` + server.String(), nil
+}
+
+// SetDoH configures the adapter to resolve names over HTTPS when using the DNS
+// server at server, with template as the URI template of the DoH endpoint. An
+// empty template uses the template Windows knows for server, if any. If
+// fallback is true, plain DNS is used when the DoH endpoint cannot be reached.
+// The settings are written under the adapter's DohInterfaceSettings key of the
+// DNS client service, which Windows reads starting with Windows 11; on older
+// versions, ErrUnsupportedWindowsVersion is returned.
+func (wintun *Adapter) SetDoH(server netip.Addr, template string, fallback bool) error {
+	path, err := wintun.dohServerKeyPath(server)
+	if err != nil {
+		return err
+	}
+	key, _, err := registry.CreateKey(registry.LOCAL_MACHINE, path, registry.SET_VALUE)
+	if err != nil {
+		return err
+	}
+	defer key.Close()
+	var flags uint64 = dohFlagEnableAuto
+	if template != "" {
+		flags = dohFlagEnable
+		err = key.SetStringValue("DohTemplate", template)
+	} else {
+		err = key.DeleteValue("DohTemplate")
+		if err == registry.ErrNotExist {
+			err = nil
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if fallback {
+		flags |= dohFlagFallbackToUDP
+	}
+	return key.SetQWordValue("DohFlags", flags)
+}
+
+// DoH returns the DNS over HTTPS settings of the adapter for server, as set by
+// SetDoH. It returns registry.ErrNotExist if none are configured.
+func (wintun *Adapter) DoH(server netip.Addr) (template string, fallback bool, err error) {
+	path, err := wintun.dohServerKeyPath(server)
+	if err != nil {
+		return
+	}
+	key, err := registry.OpenKey(registry.LOCAL_MACHINE, path, registry.QUERY_VALUE)
+	if err != nil {
+		return
+	}
+	defer key.Close()
+	flags, _, err := key.GetIntegerValue("DohFlags")
+	if err != nil {
+		return
+	}
+	if flags&dohFlagEnable != 0 {
+		template, _, err = key.GetStringValue("DohTemplate")
+		if err != nil {
+			return
+		}
+	}
+	fallback = flags&dohFlagFallbackToUDP != 0
+	return
+}
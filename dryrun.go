@@ -0,0 +1,39 @@
+//go:build windows
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package wintun
+
+import (
+	"log"
+	"sync/atomic"
+)
+
+var dryRun int32
+
+// SetDryRun sets whether the methods that configure addresses, routes, DNS
+// servers and IP interface settings, such as SetIPAddress, AddRoute, SetDNS and
+// SetMTU, only validate their arguments and log what they would change, without
+// changing anything. They return the same validation errors as they otherwise
+// would, but cannot report failures that only applying the change would reveal,
+// such as a route that already exists.
+func SetDryRun(enabled bool) {
+	var value int32
+	if enabled {
+		value = 1
+	}
+	atomic.StoreInt32(&dryRun, value)
+}
+
+// skipDryRun logs the change described by format and args and returns true if
+// dry run mode is on.
+func skipDryRun(format string, args ...interface{}) bool {
+	if atomic.LoadInt32(&dryRun) == 0 {
+		return false
+	}
+	log.Printf("Wintun dry run: would "+format, args...)
+	return true
+}
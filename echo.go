@@ -0,0 +1,67 @@
+//go:build windows
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package wintun
+
+// Reflect runs the session as a loopback responder: each received packet for
+// which filter returns true is sent back with its source and destination
+// addresses swapped, ICMP and ICMPv6 echo requests being turned into echo
+// replies, so that pinging any address routed through the adapter succeeds.
+// Packets for which filter returns false are dropped, which allows targeting,
+// for example, only pings to a specific address on an adapter that also
+// carries other traffic. A nil filter reflects everything. Reflect returns
+// like Run.
+func (session Session) Reflect(filter func(pkt []byte) bool) error {
+	return session.Run(func(packet []byte) {
+		if filter != nil && !filter(packet) {
+			return
+		}
+		reply, err := session.AllocateSendPacket(len(packet))
+		if err != nil {
+			return
+		}
+		copy(reply, packet)
+		reflectPacket(reply)
+		session.SendPacket(reply)
+	})
+}
+
+// reflectPacket turns packet around in place.
+func reflectPacket(packet []byte) {
+	if len(packet) == 0 {
+		return
+	}
+	switch packet[0] >> 4 {
+	case 4:
+		if len(packet) < 20 {
+			return
+		}
+		var addr [4]byte
+		copy(addr[:], packet[12:16])
+		copy(packet[12:16], packet[16:20])
+		copy(packet[16:20], addr[:])
+	case 6:
+		if len(packet) < 40 {
+			return
+		}
+		var addr [16]byte
+		copy(addr[:], packet[8:24])
+		copy(packet[8:24], packet[24:40])
+		copy(packet[24:40], addr[:])
+	default:
+		return
+	}
+	if protocol, offset, ok := transportPayload(packet); ok && offset < len(packet) {
+		switch {
+		case protocol == protocolICMP && packet[offset] == 8: // Echo request
+			packet[offset] = 0
+		case protocol == protocolICMPv6 && packet[offset] == 128: // Echo request
+			packet[offset] = 129
+		}
+	}
+	FixChecksums(packet)
+}
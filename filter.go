@@ -0,0 +1,55 @@
+//go:build windows
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package wintun
+
+import (
+	"sync/atomic"
+	"unsafe"
+)
+
+// protocolFilter is the set of IP protocols a session delivers.
+type protocolFilter struct {
+	allowed unsafe.Pointer // *[256]bool, nil to allow all, accessed atomically
+}
+
+func (f *protocolFilter) allows(packet []byte) bool {
+	allowed := (*[256]bool)(atomic.LoadPointer(&f.allowed))
+	if allowed == nil {
+		return true
+	}
+	protocol, ok := packetProtocol(packet)
+	return ok && allowed[protocol]
+}
+
+// packetProtocol returns the IPv4 protocol or the IPv6 next header of
+// packet, after skipping the hop-by-hop options, routing, fragment and
+// destination options extension headers, even for later fragments.
+func packetProtocol(packet []byte) (uint8, bool) {
+	protocol, _, ok := ipPayload(packet, true)
+	return protocol, ok
+}
+
+// SetReceiveFilter restricts the packets ReceivePacket, and so every receive
+// path, returns to those whose IP protocol is one of protocols, such as 1 for
+// ICMP; others are released and dropped as they are received, and counted in
+// Stats.ReceiveDropped. For IPv6, the protocol is that of the first
+// header after the hop-by-hop options, routing, fragment and destination
+// options extension headers, so a packet with an authentication or an
+// encapsulating security payload header matches protocol 51 or 50 rather than
+// that of its payload. Malformed packets are dropped. A nil protocols removes
+// the filter.
+func (session Session) SetReceiveFilter(protocols []uint8) {
+	var allowed *[256]bool
+	if protocols != nil {
+		allowed = new([256]bool)
+		for _, protocol := range protocols {
+			allowed[protocol] = true
+		}
+	}
+	atomic.StorePointer(&session.state.filter.allowed, unsafe.Pointer(allowed))
+}
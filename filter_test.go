@@ -0,0 +1,35 @@
+//go:build windows
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package wintun
+
+import "testing"
+
+func TestPacketProtocol(t *testing.T) {
+	tests := []struct {
+		name   string
+		packet string
+		want   uint8
+		ok     bool
+	}{
+		{"IPv4 with options", packetIPv4UDPOptions, protocolUDP, true},
+		{"IPv4 fragment", packetIPv4Fragment, protocolUDP, true},
+		{"IPv6 with extension headers", packetIPv6UDPExt, protocolUDP, true},
+		{"IPv6 fragment", packetIPv6Fragment, protocolUDP, true},
+		{"ICMPv6", packetIPv6ICMPv6, protocolICMPv6, true},
+		{"truncated IPv6 extension header", packetIPv6UDPExt[:2*44], 0, false},
+		{"not IP", "00112233", 0, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			protocol, ok := packetProtocol(decodePacket(t, test.packet))
+			if protocol != test.want || ok != test.ok {
+				t.Errorf("packetProtocol = %d, %v, want %d, %v", protocol, ok, test.want, test.ok)
+			}
+		})
+	}
+}
@@ -1,5 +1,5 @@
 module github.com/koomox/wintun-go
 
-go 1.17
+go 1.18
 
 require golang.org/x/sys v0.0.0-20211103235746-7861aae1554b
@@ -0,0 +1,59 @@
+//go:build windows
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package wintun
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+
+	"golang.org/x/sys/windows"
+)
+
+// adapterGUIDNamespace is the namespace of the name-based GUIDs generated by
+// GenerateGUIDFromName.
+var adapterGUIDNamespace = [16]byte{0x6d, 0x3a, 0x19, 0xc4, 0x8f, 0x52, 0x4b, 0x1e, 0xa7, 0x30, 0x5b, 0xd2, 0x0e, 0x94, 0xc1, 0x7f}
+
+// nameUUID returns the RFC 4122 version 5 UUID of name in namespace.
+func nameUUID(namespace [16]byte, name string) (uuid [16]byte) {
+	hash := sha1.New()
+	hash.Write(namespace[:])
+	hash.Write([]byte(name))
+	copy(uuid[:], hash.Sum(nil))
+	uuid[6] = uuid[6]&0x0f | 0x50
+	uuid[8] = uuid[8]&0x3f | 0x80
+	return
+}
+
+func uuidToGUID(uuid [16]byte) (guid windows.GUID) {
+	guid.Data1 = binary.BigEndian.Uint32(uuid[0:4])
+	guid.Data2 = binary.BigEndian.Uint16(uuid[4:6])
+	guid.Data3 = binary.BigEndian.Uint16(uuid[6:8])
+	copy(guid.Data4[:], uuid[8:])
+	return
+}
+
+// GenerateGUIDFromName derives a GUID deterministically from an adapter name,
+// for use as the requestedGUID of CreateAdapter, so that an adapter recreated
+// under the same name keeps its network profile. Every program using the same
+// name derives the same GUID, and would share, and overwrite, that profile;
+// GenerateGUIDFromNameSalted avoids this.
+func GenerateGUIDFromName(name string) windows.GUID {
+	return uuidToGUID(nameUUID(adapterGUIDNamespace, name))
+}
+
+// GenerateGUIDFromNameSalted is like GenerateGUIDFromName, but also derives the
+// GUID from salt, which should be unique to the application, such as its
+// name, so that applications using the same adapter name get different GUIDs.
+// An empty salt gives the same GUID as GenerateGUIDFromName.
+func GenerateGUIDFromNameSalted(name, salt string) windows.GUID {
+	namespace := adapterGUIDNamespace
+	if salt != "" {
+		namespace = nameUUID(namespace, salt)
+	}
+	return uuidToGUID(nameUUID(namespace, name))
+}
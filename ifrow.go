@@ -0,0 +1,127 @@
+//go:build windows
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package wintun
+
+import (
+	"golang.org/x/sys/windows"
+)
+
+const (
+	ifMaxStringSize        = 256
+	ifMaxPhysAddressLength = 32
+)
+
+// MibIfRow2 is the MIB_IF_ROW2 structure, describing a network interface.
+type MibIfRow2 struct {
+	InterfaceLuid               uint64
+	InterfaceIndex              uint32
+	InterfaceGuid               windows.GUID
+	Alias                       [ifMaxStringSize + 1]uint16
+	Description                 [ifMaxStringSize + 1]uint16
+	PhysicalAddressLength       uint32
+	PhysicalAddress             [ifMaxPhysAddressLength]uint8
+	PermanentPhysicalAddress    [ifMaxPhysAddressLength]uint8
+	Mtu                         uint32
+	Type                        uint32
+	TunnelType                  uint32
+	MediaType                   uint32
+	PhysicalMediumType          uint32
+	AccessType                  uint32
+	DirectionType               uint32
+	InterfaceAndOperStatusFlags uint8
+	OperStatus                  uint32
+	AdminStatus                 uint32
+	MediaConnectState           uint32
+	NetworkGuid                 windows.GUID
+	ConnectionType              uint32
+	_                           [4]byte
+	TransmitLinkSpeed           uint64
+	ReceiveLinkSpeed            uint64
+	InOctets                    uint64
+	InUcastPkts                 uint64
+	InNUcastPkts                uint64
+	InDiscards                  uint64
+	InErrors                    uint64
+	InUnknownProtos             uint64
+	InUcastOctets               uint64
+	InMulticastOctets           uint64
+	InBroadcastOctets           uint64
+	OutOctets                   uint64
+	OutUcastPkts                uint64
+	OutNUcastPkts               uint64
+	OutDiscards                 uint64
+	OutErrors                   uint64
+	OutUcastOctets              uint64
+	OutMulticastOctets          uint64
+	OutBroadcastOctets          uint64
+	OutQLen                     uint64
+}
+
+// IfRow returns the full interface information that GetIfEntry2 reports for
+// the adapter, for fields that have no dedicated accessor. Wintun adapters
+// operate at layer 3, so fields that only make sense for layer 2 interfaces,
+// such as PhysicalAddress, are zero.
+func (wintun *Adapter) IfRow() (*MibIfRow2, error) {
+	row := &MibIfRow2{InterfaceLuid: wintun.LUID()}
+	err := getIfEntry2(row)
+	if err != nil {
+		return nil, err
+	}
+	return row, nil
+}
+
+// OperationalMTU returns the MTU the interface is actually operating with, as
+// reported by GetIfEntry2, which can differ from the MTU configured on its IP
+// interfaces if a change did not take effect.
+func (wintun *Adapter) OperationalMTU() (uint32, error) {
+	row, err := wintun.IfRow()
+	if err != nil {
+		return 0, err
+	}
+	return row.Mtu, nil
+}
+
+// AdapterDetail describes a Wintun adapter present on the system.
+type AdapterDetail struct {
+	Name       string
+	LUID       uint64
+	GUID       windows.GUID
+	OperStatus uint32 // IF_OPER_STATUS, such as 1 for up and 2 for down
+
+	// InUse reports whether the adapter appears to have a session running,
+	// which Wintun signals by reporting the media as connected. It is best
+	// effort: it cannot tell which process runs the session, and it lags
+	// briefly behind sessions starting and ending.
+	InUse bool
+}
+
+// EnumerateAdaptersDetailed returns every Wintun adapter on the system,
+// including ones created by other processes, along with its status. Adapters
+// that disappear while being enumerated are left out.
+func EnumerateAdaptersDetailed() ([]AdapterDetail, error) {
+	const MediaConnectStateConnected = 1
+	luids, err := wintunLUIDs()
+	if err != nil {
+		return nil, err
+	}
+	details := make([]AdapterDetail, 0, len(luids))
+	for _, luid := range luids {
+		row := &MibIfRow2{InterfaceLuid: luid}
+		if getIfEntry2(row) != nil {
+			continue
+		}
+		details = append(details, AdapterDetail{
+			Name:       windows.UTF16ToString(row.Alias[:]),
+			LUID:       luid,
+			GUID:       row.InterfaceGuid,
+			OperStatus: row.OperStatus,
+			InUse:      row.MediaConnectState == MediaConnectStateConnected,
+		})
+	}
+	return details, nil
+}
@@ -0,0 +1,143 @@
+//go:build windows
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package wintun
+
+import (
+	"net/netip"
+	"time"
+
+	"golang.org/x/sys/windows"
+)
+
+// ipInterface returns the adapter's IP interface settings for family.
+func (wintun *Adapter) ipInterface(family uint16) (*mibIPInterfaceRow, error) {
+	row := &mibIPInterfaceRow{Family: family, InterfaceLUID: wintun.LUID()}
+	err := getIPInterfaceEntry(row)
+	if err != nil {
+		return nil, err
+	}
+	return row, nil
+}
+
+// setIPInterface applies IP interface settings read by ipInterface.
+func setIPInterface(row *mibIPInterfaceRow) error {
+	if row.Family == windows.AF_INET {
+		// SetIpInterfaceEntry rejects IPv4 rows with a site prefix length.
+		row.SitePrefixLength = 0
+	}
+	if skipDryRun("set IP interface settings of interface %d, family %d: MTU %d, metric %d, automatic metric %t", row.InterfaceLUID, row.Family, row.NLMTU, row.Metric, row.UseAutomaticMetric) {
+		return nil
+	}
+	return setIPInterfaceEntry(row)
+}
+
+// SetMTU sets the MTU of the adapter's IP interface for family,
+// windows.AF_INET or windows.AF_INET6. It must be at least 576 for IPv4 and
+// 1280 for IPv6, and at most PacketSizeMax.
+func (wintun *Adapter) SetMTU(family int, mtu uint32) error {
+	var min uint32
+	switch family {
+	case windows.AF_INET:
+		min = 576
+	case windows.AF_INET6:
+		min = 1280
+	default:
+		return windows.ERROR_INVALID_PARAMETER
+	}
+	if mtu < min || mtu > PacketSizeMax {
+		return windows.ERROR_INVALID_PARAMETER
+	}
+	row, err := wintun.ipInterface(uint16(family))
+	if err != nil {
+		return err
+	}
+	row.NLMTU = mtu
+	return setIPInterface(row)
+}
+
+// MaxBaseReachableTime is the largest base reachable time for neighbor
+// unreachability detection, per RFC 4861.
+const MaxBaseReachableTime = time.Hour
+
+// SetBaseReachableTime sets the base time for which an IPv6 neighbor is
+// considered reachable after a reachability confirmation. It must be between
+// one millisecond and MaxBaseReachableTime. The Windows default is 30 seconds.
+func (wintun *Adapter) SetBaseReachableTime(d time.Duration) error {
+	if d < time.Millisecond || d > MaxBaseReachableTime {
+		return windows.ERROR_INVALID_PARAMETER
+	}
+	row, err := wintun.ipInterface(windows.AF_INET6)
+	if err != nil {
+		return err
+	}
+	row.BaseReachableTime = uint32(d / time.Millisecond)
+	return setIPInterface(row)
+}
+
+// BaseReachableTime returns the base time for which an IPv6 neighbor is
+// considered reachable after a reachability confirmation.
+func (wintun *Adapter) BaseReachableTime() (time.Duration, error) {
+	row, err := wintun.ipInterface(windows.AF_INET6)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(row.BaseReachableTime) * time.Millisecond, nil
+}
+
+// IPv6Config summarizes the IPv6 configuration of an adapter.
+type IPv6Config struct {
+	LinkLocal          netip.Addr     // First link-local address, invalid if none
+	Addresses          []netip.Prefix // Unicast addresses that are not link-local
+	MTU                uint32
+	HopLimit           uint8  // System-wide default hop limit
+	RouterDiscovery    bool   // Whether router advertisements are used
+	AdvertisingEnabled bool   // Whether the interface sends router advertisements
+	DADTransmits       uint32 // Duplicate address detection probes, 0 if disabled
+	ForwardingEnabled  bool
+}
+
+// IPv6Config returns a summary of the adapter's IPv6 configuration, gathered
+// from its IP interface settings and unicast address table.
+func (wintun *Adapter) IPv6Config() (IPv6Config, error) {
+	const RouterDiscoveryDisabled = 0
+	row, err := wintun.ipInterface(windows.AF_INET6)
+	if err != nil {
+		return IPv6Config{}, err
+	}
+	config := IPv6Config{
+		MTU:                row.NLMTU,
+		RouterDiscovery:    row.RouterDiscoveryBehavior != RouterDiscoveryDisabled,
+		AdvertisingEnabled: row.AdvertisingEnabled,
+		DADTransmits:       row.DadTransmits,
+		ForwardingEnabled:  row.ForwardingEnabled,
+	}
+	var stats mibIPStats
+	err = getIPStatisticsEx(&stats, windows.AF_INET6)
+	if err != nil {
+		return IPv6Config{}, err
+	}
+	config.HopLimit = uint8(stats.DefaultTTL)
+	rows, err := getUnicastIPAddressTable(windows.AF_INET6)
+	if err != nil {
+		return IPv6Config{}, err
+	}
+	for i := range rows {
+		if rows[i].InterfaceLUID != row.InterfaceLUID {
+			continue
+		}
+		addr := rows[i].Address.addr()
+		if addr.IsLinkLocalUnicast() {
+			if !config.LinkLocal.IsValid() {
+				config.LinkLocal = addr
+			}
+		} else {
+			config.Addresses = append(config.Addresses, netip.PrefixFrom(addr, int(rows[i].OnLinkPrefixLength)))
+		}
+	}
+	return config, nil
+}
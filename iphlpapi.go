@@ -0,0 +1,342 @@
+//go:build windows
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package wintun
+
+import (
+	"net/netip"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	modiphlpapi                         = windows.NewLazySystemDLL("iphlpapi.dll")
+	procConvertInterfaceLuidToAlias     = modiphlpapi.NewProc("ConvertInterfaceLuidToAlias")
+	procConvertInterfaceLuidToGuid      = modiphlpapi.NewProc("ConvertInterfaceLuidToGuid")
+	procConvertInterfaceLuidToIndex     = modiphlpapi.NewProc("ConvertInterfaceLuidToIndex")
+	procConvertInterfaceGuidToLuid      = modiphlpapi.NewProc("ConvertInterfaceGuidToLuid")
+	procFreeMibTable                    = modiphlpapi.NewProc("FreeMibTable")
+	procGetIfEntry2                     = modiphlpapi.NewProc("GetIfEntry2")
+	procGetIpForwardTable2              = modiphlpapi.NewProc("GetIpForwardTable2")
+	procGetIpInterfaceEntry             = modiphlpapi.NewProc("GetIpInterfaceEntry")
+	procGetIpStatisticsEx               = modiphlpapi.NewProc("GetIpStatisticsEx")
+	procIcmpCloseHandle                 = modiphlpapi.NewProc("IcmpCloseHandle")
+	procIcmpCreateFile                  = modiphlpapi.NewProc("IcmpCreateFile")
+	procIcmpSendEcho                    = modiphlpapi.NewProc("IcmpSendEcho")
+	procGetUnicastIpAddressTable        = modiphlpapi.NewProc("GetUnicastIpAddressTable")
+	procSetIpInterfaceEntry             = modiphlpapi.NewProc("SetIpInterfaceEntry")
+	procCreateIpForwardEntry2           = modiphlpapi.NewProc("CreateIpForwardEntry2")
+	procGetBestRoute2                   = modiphlpapi.NewProc("GetBestRoute2")
+	procInitializeIpForwardEntry        = modiphlpapi.NewProc("InitializeIpForwardEntry")
+	procInitializeUnicastIpAddressEntry = modiphlpapi.NewProc("InitializeUnicastIpAddressEntry")
+	procCreateUnicastIpAddressEntry     = modiphlpapi.NewProc("CreateUnicastIpAddressEntry")
+	procDeleteUnicastIpAddressEntry     = modiphlpapi.NewProc("DeleteUnicastIpAddressEntry")
+)
+
+// rawSockaddrInet is the SOCKADDR_INET union of sockaddr_in and sockaddr_in6.
+type rawSockaddrInet struct {
+	_      [0]uint32
+	Family uint16
+	data   [26]byte
+}
+
+func (sa *rawSockaddrInet) setAddr(addr netip.Addr) error {
+	*sa = rawSockaddrInet{}
+	addr = addr.Unmap()
+	switch {
+	case addr.Is4():
+		sa.Family = windows.AF_INET
+		ip := addr.As4()
+		copy(sa.data[2:6], ip[:])
+	case addr.Is6():
+		sa.Family = windows.AF_INET6
+		ip := addr.As16()
+		copy(sa.data[6:22], ip[:])
+	default:
+		return windows.ERROR_INVALID_PARAMETER
+	}
+	return nil
+}
+
+func (sa *rawSockaddrInet) addr() netip.Addr {
+	switch sa.Family {
+	case windows.AF_INET:
+		return netip.AddrFrom4(*(*[4]byte)(sa.data[2:6]))
+	case windows.AF_INET6:
+		return netip.AddrFrom16(*(*[16]byte)(sa.data[6:22]))
+	}
+	return netip.Addr{}
+}
+
+// ipAddressPrefix is the IP_ADDRESS_PREFIX structure.
+type ipAddressPrefix struct {
+	Prefix       rawSockaddrInet
+	PrefixLength uint8
+	_            [3]byte
+}
+
+// mibIPforwardRow2 is the MIB_IPFORWARD_ROW2 structure.
+type mibIPforwardRow2 struct {
+	InterfaceLUID        uint64
+	InterfaceIndex       uint32
+	DestinationPrefix    ipAddressPrefix
+	NextHop              rawSockaddrInet
+	SitePrefixLength     uint8
+	ValidLifetime        uint32
+	PreferredLifetime    uint32
+	Metric               uint32
+	Protocol             uint32
+	Loopback             bool
+	AutoconfigureAddress bool
+	Publish              bool
+	Immortal             bool
+	Age                  uint32
+	Origin               uint32
+}
+
+// mibUnicastIPAddressRow is the MIB_UNICASTIPADDRESS_ROW structure.
+type mibUnicastIPAddressRow struct {
+	Address            rawSockaddrInet
+	_                  [4]byte
+	InterfaceLUID      uint64
+	InterfaceIndex     uint32
+	PrefixOrigin       uint32
+	SuffixOrigin       uint32
+	ValidLifetime      uint32
+	PreferredLifetime  uint32
+	OnLinkPrefixLength uint8
+	SkipAsSource       bool
+	DadState           uint32
+	ScopeID            uint32
+	CreationTimeStamp  int64
+}
+
+// mibIPInterfaceRow is the MIB_IPINTERFACE_ROW structure.
+type mibIPInterfaceRow struct {
+	Family                               uint16
+	_                                    [6]byte
+	InterfaceLUID                        uint64
+	InterfaceIndex                       uint32
+	MaxReassemblySize                    uint32
+	InterfaceIdentifier                  uint64
+	MinRouterAdvertisementInterval       uint32
+	MaxRouterAdvertisementInterval       uint32
+	AdvertisingEnabled                   bool
+	ForwardingEnabled                    bool
+	WeakHostSend                         bool
+	WeakHostReceive                      bool
+	UseAutomaticMetric                   bool
+	UseNeighborUnreachabilityDetection   bool
+	ManagedAddressConfigurationSupported bool
+	OtherStatefulConfigurationSupported  bool
+	AdvertiseDefaultRoute                bool
+	RouterDiscoveryBehavior              uint32
+	DadTransmits                         uint32
+	BaseReachableTime                    uint32
+	RetransmitTime                       uint32
+	PathMTUDiscoveryTimeout              uint32
+	LinkLocalAddressBehavior             uint32
+	LinkLocalAddressTimeout              uint32
+	ZoneIndices                          [16]uint32
+	SitePrefixLength                     uint32
+	Metric                               uint32
+	NLMTU                                uint32
+	Connected                            bool
+	SupportsWakeUpPatterns               bool
+	SupportsNeighborDiscovery            bool
+	SupportsRouterDiscovery              bool
+	ReachableTime                        uint32
+	TransmitOffload                      uint8
+	ReceiveOffload                       uint8
+	DisableDefaultRoutes                 bool
+}
+
+// mibIPStats is the MIB_IPSTATS structure, of which only the leading fields
+// are named.
+type mibIPStats struct {
+	Forwarding uint32
+	DefaultTTL uint32
+	_          [21]uint32
+}
+
+func getIPStatisticsEx(stats *mibIPStats, family uint32) (err error) {
+	r0, _, _ := syscall.Syscall(procGetIpStatisticsEx.Addr(), 2, uintptr(unsafe.Pointer(stats)), uintptr(family), 0)
+	if r0 != 0 {
+		err = syscall.Errno(r0)
+	}
+	return
+}
+
+func getIPInterfaceEntry(row *mibIPInterfaceRow) (err error) {
+	r0, _, _ := syscall.Syscall(procGetIpInterfaceEntry.Addr(), 1, uintptr(unsafe.Pointer(row)), 0, 0)
+	if r0 != 0 {
+		err = syscall.Errno(r0)
+	}
+	return
+}
+
+func setIPInterfaceEntry(row *mibIPInterfaceRow) (err error) {
+	r0, _, _ := syscall.Syscall(procSetIpInterfaceEntry.Addr(), 1, uintptr(unsafe.Pointer(row)), 0, 0)
+	if r0 != 0 {
+		err = syscall.Errno(r0)
+	}
+	return
+}
+
+func freeMibTable(memory unsafe.Pointer) {
+	syscall.Syscall(procFreeMibTable.Addr(), 1, uintptr(memory), 0, 0)
+}
+
+// getUnicastIPAddressTable returns a copy of the unicast address table for
+// family, which may be AF_UNSPEC for both address families.
+func getUnicastIPAddressTable(family uint16) ([]mibUnicastIPAddressRow, error) {
+	var table unsafe.Pointer
+	r0, _, _ := syscall.Syscall(procGetUnicastIpAddressTable.Addr(), 2, uintptr(family), uintptr(unsafe.Pointer(&table)), 0)
+	if r0 != 0 {
+		return nil, syscall.Errno(r0)
+	}
+	defer freeMibTable(table)
+	numEntries := *(*uint32)(table)
+	rows := unsafe.Slice((*mibUnicastIPAddressRow)(unsafe.Add(table, 8)), numEntries)
+	return append([]mibUnicastIPAddressRow(nil), rows...), nil
+}
+
+func getIPForwardTable2(family uint16) ([]mibIPforwardRow2, error) {
+	var table unsafe.Pointer
+	r0, _, _ := syscall.Syscall(procGetIpForwardTable2.Addr(), 2, uintptr(family), uintptr(unsafe.Pointer(&table)), 0)
+	if r0 != 0 {
+		return nil, syscall.Errno(r0)
+	}
+	defer freeMibTable(table)
+	numEntries := *(*uint32)(table)
+	rows := unsafe.Slice((*mibIPforwardRow2)(unsafe.Add(table, 8)), numEntries)
+	return append([]mibIPforwardRow2(nil), rows...), nil
+}
+
+func initializeUnicastIPAddressEntry(row *mibUnicastIPAddressRow) {
+	syscall.Syscall(procInitializeUnicastIpAddressEntry.Addr(), 1, uintptr(unsafe.Pointer(row)), 0, 0)
+}
+
+func createUnicastIPAddressEntry(row *mibUnicastIPAddressRow) (err error) {
+	r0, _, _ := syscall.Syscall(procCreateUnicastIpAddressEntry.Addr(), 1, uintptr(unsafe.Pointer(row)), 0, 0)
+	if r0 != 0 {
+		err = syscall.Errno(r0)
+	}
+	return
+}
+
+func deleteUnicastIPAddressEntry(row *mibUnicastIPAddressRow) (err error) {
+	r0, _, _ := syscall.Syscall(procDeleteUnicastIpAddressEntry.Addr(), 1, uintptr(unsafe.Pointer(row)), 0, 0)
+	if r0 != 0 {
+		err = syscall.Errno(r0)
+	}
+	return
+}
+
+func initializeIPForwardEntry(row *mibIPforwardRow2) {
+	syscall.Syscall(procInitializeIpForwardEntry.Addr(), 1, uintptr(unsafe.Pointer(row)), 0, 0)
+}
+
+func createIPForwardEntry2(row *mibIPforwardRow2) (err error) {
+	r0, _, _ := syscall.Syscall(procCreateIpForwardEntry2.Addr(), 1, uintptr(unsafe.Pointer(row)), 0, 0)
+	if r0 != 0 {
+		err = syscall.Errno(r0)
+	}
+	return
+}
+
+func getBestRoute2(luid *uint64, index uint32, source *rawSockaddrInet, destination *rawSockaddrInet, sortOptions uint32, bestRoute *mibIPforwardRow2, bestSource *rawSockaddrInet) (err error) {
+	r0, _, _ := syscall.Syscall9(procGetBestRoute2.Addr(), 7, uintptr(unsafe.Pointer(luid)), uintptr(index), uintptr(unsafe.Pointer(source)), uintptr(unsafe.Pointer(destination)), uintptr(sortOptions), uintptr(unsafe.Pointer(bestRoute)), uintptr(unsafe.Pointer(bestSource)), 0, 0)
+	if r0 != 0 {
+		err = syscall.Errno(r0)
+	}
+	return
+}
+
+func convertInterfaceLUIDToAlias(luid *uint64, alias *uint16, length uintptr) (err error) {
+	r0, _, _ := syscall.Syscall(procConvertInterfaceLuidToAlias.Addr(), 3, uintptr(unsafe.Pointer(luid)), uintptr(unsafe.Pointer(alias)), length)
+	if r0 != 0 {
+		err = syscall.Errno(r0)
+	}
+	return
+}
+
+func convertInterfaceLUIDToGUID(luid *uint64, guid *windows.GUID) (err error) {
+	r0, _, _ := syscall.Syscall(procConvertInterfaceLuidToGuid.Addr(), 2, uintptr(unsafe.Pointer(luid)), uintptr(unsafe.Pointer(guid)), 0)
+	if r0 != 0 {
+		err = syscall.Errno(r0)
+	}
+	return
+}
+
+func convertInterfaceLUIDToIndex(luid *uint64, index *uint32) (err error) {
+	r0, _, _ := syscall.Syscall(procConvertInterfaceLuidToIndex.Addr(), 2, uintptr(unsafe.Pointer(luid)), uintptr(unsafe.Pointer(index)), 0)
+	if r0 != 0 {
+		err = syscall.Errno(r0)
+	}
+	return
+}
+
+func convertInterfaceGUIDToLUID(guid *windows.GUID, luid *uint64) (err error) {
+	r0, _, _ := syscall.Syscall(procConvertInterfaceGuidToLuid.Addr(), 2, uintptr(unsafe.Pointer(guid)), uintptr(unsafe.Pointer(luid)), 0)
+	if r0 != 0 {
+		err = syscall.Errno(r0)
+	}
+	return
+}
+
+// ipOptionInformation is the IP_OPTION_INFORMATION structure.
+type ipOptionInformation struct {
+	TTL         uint8
+	TOS         uint8
+	Flags       uint8
+	OptionsSize uint8
+	OptionsData *byte
+}
+
+// icmpEchoReply is the ICMP_ECHO_REPLY structure.
+type icmpEchoReply struct {
+	Address       [4]byte
+	Status        uint32
+	RoundTripTime uint32
+	DataSize      uint16
+	Reserved      uint16
+	Data          uintptr
+	Options       ipOptionInformation
+}
+
+func icmpCreateFile() (handle windows.Handle, err error) {
+	r0, _, e1 := syscall.Syscall(procIcmpCreateFile.Addr(), 0, 0, 0, 0)
+	handle = windows.Handle(r0)
+	if handle == windows.InvalidHandle {
+		err = e1
+	}
+	return
+}
+
+func icmpCloseHandle(handle windows.Handle) {
+	syscall.Syscall(procIcmpCloseHandle.Addr(), 1, uintptr(handle), 0, 0)
+}
+
+func icmpSendEcho(handle windows.Handle, destination [4]byte, data []byte, options *ipOptionInformation, reply []byte, timeout uint32) (replies uint32, err error) {
+	r0, _, e1 := syscall.Syscall9(procIcmpSendEcho.Addr(), 8, uintptr(handle), uintptr(*(*uint32)(unsafe.Pointer(&destination[0]))), uintptr(unsafe.Pointer(&data[0])), uintptr(len(data)), uintptr(unsafe.Pointer(options)), uintptr(unsafe.Pointer(&reply[0])), uintptr(len(reply)), uintptr(timeout), 0)
+	replies = uint32(r0)
+	if replies == 0 {
+		err = e1
+	}
+	return
+}
+
+func getIfEntry2(row *MibIfRow2) (err error) {
+	r0, _, _ := syscall.Syscall(procGetIfEntry2.Addr(), 1, uintptr(unsafe.Pointer(row)), 0, 0)
+	if r0 != 0 {
+		err = syscall.Errno(r0)
+	}
+	return
+}
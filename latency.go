@@ -0,0 +1,97 @@
+//go:build windows
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package wintun
+
+import (
+	"math"
+	"math/bits"
+	"sync/atomic"
+	"time"
+)
+
+// latencyHistogram counts durations in power of two buckets of nanoseconds,
+// bucket i holding durations from 2^(i-1) ns to below 2^i ns.
+type latencyHistogram struct {
+	enabled int32 // accessed atomically, as are all other fields
+	count   uint64
+	sum     uint64
+	min     uint64 // plus one, so that zero means none yet
+	max     uint64
+	buckets [65]uint64
+}
+
+func (h *latencyHistogram) record(d time.Duration) {
+	ns := uint64(d)
+	if d < 0 {
+		ns = 0
+	}
+	atomic.AddUint64(&h.count, 1)
+	atomic.AddUint64(&h.sum, ns)
+	atomic.AddUint64(&h.buckets[bits.Len64(ns)], 1)
+	for {
+		min := atomic.LoadUint64(&h.min)
+		if min != 0 && min-1 <= ns || atomic.CompareAndSwapUint64(&h.min, min, ns+1) {
+			break
+		}
+	}
+	for {
+		max := atomic.LoadUint64(&h.max)
+		if max >= ns || atomic.CompareAndSwapUint64(&h.max, max, ns) {
+			break
+		}
+	}
+}
+
+// LatencyStats summarizes the time Run's handler took per packet. P99 is an
+// upper bound, accurate to a factor of two.
+type LatencyStats struct {
+	Count uint64
+	Min   time.Duration
+	Avg   time.Duration
+	P99   time.Duration
+	Max   time.Duration
+}
+
+// EnableHandlerLatency sets whether Run measures how long its handler takes
+// for each packet, for HandlerLatency to report. Measuring costs two clock
+// reads per packet, so it is off by default. Enabling it again keeps the
+// measurements made so far.
+func (session Session) EnableHandlerLatency(enabled bool) {
+	var value int32
+	if enabled {
+		value = 1
+	}
+	atomic.StoreInt32(&session.state.latency.enabled, value)
+}
+
+// HandlerLatency returns the distribution of the time between Run receiving a
+// packet and its handler returning, over the packets handled while
+// EnableHandlerLatency was on.
+func (session Session) HandlerLatency() LatencyStats {
+	h := &session.state.latency
+	stats := LatencyStats{Count: atomic.LoadUint64(&h.count)}
+	if stats.Count == 0 {
+		return stats
+	}
+	stats.Min = time.Duration(atomic.LoadUint64(&h.min) - 1)
+	stats.Max = time.Duration(atomic.LoadUint64(&h.max))
+	stats.Avg = time.Duration(atomic.LoadUint64(&h.sum) / stats.Count)
+	threshold := uint64(math.Ceil(float64(stats.Count) * 0.99))
+	var seen uint64
+	for i := range h.buckets {
+		seen += atomic.LoadUint64(&h.buckets[i])
+		if seen >= threshold {
+			stats.P99 = time.Duration(uint64(1)<<i - 1)
+			break
+		}
+	}
+	if stats.P99 > stats.Max || stats.P99 == 0 {
+		stats.P99 = stats.Max
+	}
+	return stats
+}
@@ -0,0 +1,63 @@
+//go:build windows
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package wintun
+
+import (
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"golang.org/x/sys/windows"
+)
+
+// ErrAdapterLocked is returned by AcquireAdapterLock when another process, or
+// another call in this one, holds the lock of the adapter name.
+var ErrAdapterLocked = errors.New("Adapter name is locked by another instance")
+
+// AcquireAdapterLock takes an exclusive, system-wide lock on an adapter name,
+// so that a supervisor can make sure a single instance manages the adapter,
+// including across restarts. It returns ErrAdapterLocked without waiting if
+// the lock is held. The lock is a byte range lock on a file under
+// %ProgramData%\Wintun\Locks, which the release function unlocks. If the
+// process terminates without calling it, even abruptly, Windows releases the
+// lock along with the process's handles, so a stale lock never outlives its
+// holder; the lock file itself stays behind, unlocked.
+func AcquireAdapterLock(name string) (release func(), err error) {
+	programData, err := windows.KnownFolderPath(windows.FOLDERID_ProgramData, windows.KF_FLAG_DEFAULT)
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Join(programData, "Wintun", "Locks")
+	err = os.MkdirAll(dir, 0o700)
+	if err != nil {
+		return nil, err
+	}
+	// Hex encoding keeps any adapter name a valid and distinct file name.
+	file, err := os.OpenFile(filepath.Join(dir, hex.EncodeToString([]byte(name))+".lock"), os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	handle := windows.Handle(file.Fd())
+	err = windows.LockFileEx(handle, windows.LOCKFILE_EXCLUSIVE_LOCK|windows.LOCKFILE_FAIL_IMMEDIATELY, 0, 1, 0, &windows.Overlapped{})
+	if err == windows.ERROR_LOCK_VIOLATION {
+		file.Close()
+		return nil, ErrAdapterLocked
+	} else if err != nil {
+		file.Close()
+		return nil, err
+	}
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			windows.UnlockFileEx(handle, 0, 1, 0, &windows.Overlapped{})
+			file.Close()
+		})
+	}, nil
+}
@@ -0,0 +1,41 @@
+//go:build windows
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package wintun
+
+import (
+	"net"
+
+	"golang.org/x/sys/windows"
+)
+
+// LUID is the locally unique identifier of a network interface, as returned by
+// (*Adapter).LUID.
+type LUID uint64
+
+// GUID returns the network GUID of the interface.
+func (luid LUID) GUID() (guid windows.GUID, err error) {
+	l := uint64(luid)
+	err = convertInterfaceLUIDToGUID(&l, &guid)
+	return
+}
+
+// Index returns the interface index of the interface.
+func (luid LUID) Index() (index uint32, err error) {
+	l := uint64(luid)
+	err = convertInterfaceLUIDToIndex(&l, &index)
+	return
+}
+
+// Interface returns the net.Interface for the interface.
+func (luid LUID) Interface() (*net.Interface, error) {
+	index, err := luid.Index()
+	if err != nil {
+		return nil, err
+	}
+	return net.InterfaceByIndex(int(index))
+}
@@ -0,0 +1,153 @@
+//go:build windows
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package wintun
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// sessionRegistry tracks the sessions that have been started and not ended.
+type sessionRegistry struct {
+	mu       sync.Mutex
+	sessions map[*sessionState]Session
+}
+
+var liveSessions sessionRegistry
+
+func (r *sessionRegistry) add(session Session) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.sessions == nil {
+		r.sessions = make(map[*sessionState]Session)
+	}
+	r.sessions[session.state] = session
+}
+
+func (r *sessionRegistry) remove(state *sessionState) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.sessions, state)
+}
+
+func (r *sessionRegistry) snapshot() []Session {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	sessions := make([]Session, 0, len(r.sessions))
+	for _, session := range r.sessions {
+		sessions = append(sessions, session)
+	}
+	return sessions
+}
+
+var sessionMetrics = []struct {
+	name, help string
+	value      func(Stats) uint64
+}{
+	{"wintun_received_packets_total", "Packets received from the adapter.", func(s Stats) uint64 { return s.ReceivedPackets }},
+	{"wintun_received_bytes_total", "Bytes received from the adapter.", func(s Stats) uint64 { return s.ReceivedBytes }},
+	{"wintun_sent_packets_total", "Packets sent to the adapter.", func(s Stats) uint64 { return s.SentPackets }},
+	{"wintun_sent_bytes_total", "Bytes sent to the adapter.", func(s Stats) uint64 { return s.SentBytes }},
+	{"wintun_send_overflows_total", "Send allocations that failed because the ring was full.", func(s Stats) uint64 { return s.SendOverflows }},
+	{"wintun_receive_dropped_total", "Received packets dropped by a receive transform or filter.", func(s Stats) uint64 { return s.ReceiveDropped }},
+	{"wintun_send_dropped_total", "Packets dropped by a send transform.", func(s Stats) uint64 { return s.SendDropped }},
+}
+
+// metricLabelName turns a tag key into a valid Prometheus label name.
+func metricLabelName(key string) string {
+	var b strings.Builder
+	b.WriteString("tag_")
+	for _, r := range key {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' {
+			b.WriteRune(r)
+		} else {
+			b.WriteByte('_')
+		}
+	}
+	return b.String()
+}
+
+var metricLabelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// sessionLabels returns the Prometheus labels of a session: the LUID of its
+// adapter and the adapter's tags, prefixed with tag_.
+func sessionLabels(state *sessionState) string {
+	labels := append([]string{fmt.Sprintf(`luid="%d"`, state.adapter.LUID())}, tagLabels(state.adapter.Tags())...)
+	return "{" + strings.Join(labels, ",") + "}"
+}
+
+// tagLabels returns the Prometheus labels of tags, sorted by key. Keys that
+// map to the same label name, such as "a-b" and "a_b", get numbered suffixes
+// in key order, "tag_a_b" and "tag_a_b_2", so no series repeats a label.
+func tagLabels(tags map[string]string) []string {
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	used := make(map[string]bool, len(keys))
+	labels := make([]string, 0, len(keys))
+	for _, key := range keys {
+		base := metricLabelName(key)
+		name := base
+		for i := 2; used[name]; i++ {
+			name = fmt.Sprintf("%s_%d", base, i)
+		}
+		used[name] = true
+		labels = append(labels, fmt.Sprintf(`%s="%s"`, name, metricLabelValueEscaper.Replace(tags[key])))
+	}
+	return labels
+}
+
+// metricSeries sorts sessions by their labels, for a stable output order.
+type metricSeries struct {
+	labels []string
+	states []*sessionState
+}
+
+func (s metricSeries) Len() int           { return len(s.labels) }
+func (s metricSeries) Less(i, j int) bool { return s.labels[i] < s.labels[j] }
+func (s metricSeries) Swap(i, j int) {
+	s.labels[i], s.labels[j] = s.labels[j], s.labels[i]
+	s.states[i], s.states[j] = s.states[j], s.states[i]
+}
+
+// WriteMetrics writes the counters of every running session, as reported by
+// Session.Stats, to w in the Prometheus text exposition format, so that it can
+// back a /metrics endpoint. Each series is labeled with the LUID of the
+// session's adapter and with the adapter's tags, as set by SetTag, whose keys
+// are prefixed with tag_ and have characters not allowed in label names
+// replaced with underscores.
+func WriteMetrics(w io.Writer) error {
+	sessions := liveSessions.snapshot()
+	states := make([]*sessionState, len(sessions))
+	for i := range sessions {
+		states[i] = sessions[i].state
+	}
+	labels := make([]string, len(states))
+	stats := make([]Stats, len(states))
+	for i, state := range states {
+		labels[i] = sessionLabels(state)
+	}
+	sort.Sort(metricSeries{labels, states})
+	for i, state := range states {
+		stats[i] = Session{state: state}.Stats()
+	}
+	bw := bufio.NewWriter(w)
+	for _, metric := range sessionMetrics {
+		fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s counter\n", metric.name, metric.help, metric.name)
+		for i := range states {
+			fmt.Fprintf(bw, "%s%s %d\n", metric.name, labels[i], metric.value(stats[i]))
+		}
+	}
+	return bw.Flush()
+}
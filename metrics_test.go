@@ -0,0 +1,34 @@
+//go:build windows
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package wintun
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTagLabels(t *testing.T) {
+	tests := []struct {
+		name string
+		tags map[string]string
+		want string
+	}{
+		{"none", nil, ""},
+		{"sorted", map[string]string{"tenant": "acme", "region": "eu"}, `tag_region="eu",tag_tenant="acme"`},
+		{"escaped", map[string]string{"note": "a \"b\"\n\\"}, `tag_note="a \"b\"\n\\"`},
+		{"colliding keys", map[string]string{"a-b": "1", "a_b": "2", "a.b": "3"}, `tag_a_b="1",tag_a_b_2="3",tag_a_b_3="2"`},
+		{"suffix taken by a key", map[string]string{"a-b": "1", "a_b": "2", "a_b_2": "3"}, `tag_a_b="1",tag_a_b_2="2",tag_a_b_2_2="3"`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := strings.Join(tagLabels(test.tags), ","); got != test.want {
+				t.Errorf("tagLabels = %s, want %s", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,203 @@
+//go:build windows
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package wintun
+
+import (
+	"runtime"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	modole32             = windows.NewLazySystemDLL("ole32.dll")
+	procCoCreateInstance = modole32.NewProc("CoCreateInstance")
+
+	clsidNetworkListManager = windows.GUID{Data1: 0xdcb00c01, Data2: 0x570f, Data3: 0x4a9b, Data4: [8]byte{0x8d, 0x69, 0x19, 0x9f, 0xdb, 0xa5, 0x72, 0x3b}}
+	iidINetworkListManager  = windows.GUID{Data1: 0xdcb00000, Data2: 0x570f, Data3: 0x4a9b, Data4: [8]byte{0x8d, 0x69, 0x19, 0x9f, 0xdb, 0xa5, 0x72, 0x3b}}
+)
+
+// Vtable indices of the Network List Manager interface methods used below.
+const (
+	comRelease = 2
+
+	networkListManagerGetNetworks           = 7
+	networkListManagerGetNetworkConnections = 9
+
+	enumNext = 8
+
+	networkConnectionGetNetwork   = 7
+	networkConnectionGetAdapterID = 12
+
+	networkGetNetworkID = 11
+	networkGetCategory  = 18
+	networkSetCategory  = 19
+)
+
+// comMethod returns the address of method index of a COM object.
+func comMethod(object unsafe.Pointer, index int) uintptr {
+	vtable := *(*unsafe.Pointer)(object)
+	return *(*uintptr)(unsafe.Add(vtable, index*int(unsafe.Sizeof(uintptr(0)))))
+}
+
+func comReleaseObject(object unsafe.Pointer) {
+	syscall.SyscallN(comMethod(object, comRelease), uintptr(object))
+}
+
+func hresultError(hr uintptr) error {
+	if int32(hr) < 0 {
+		return syscall.Errno(hr)
+	}
+	return nil
+}
+
+// withNetworkListManager calls fn with an INetworkListManager, on a thread
+// initialized for COM.
+func withNetworkListManager(fn func(manager unsafe.Pointer) error) error {
+	const (
+		CLSCTX_ALL         = 0x17
+		RPC_E_CHANGED_MODE = syscall.Errno(0x80010106)
+	)
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	switch err := windows.CoInitializeEx(0, windows.COINIT_MULTITHREADED); err {
+	case nil, syscall.Errno(1): // S_FALSE means already initialized
+		defer windows.CoUninitialize()
+	case RPC_E_CHANGED_MODE:
+	default:
+		return err
+	}
+	var manager unsafe.Pointer
+	r0, _, _ := syscall.SyscallN(procCoCreateInstance.Addr(), uintptr(unsafe.Pointer(&clsidNetworkListManager)), 0, CLSCTX_ALL, uintptr(unsafe.Pointer(&iidINetworkListManager)), uintptr(unsafe.Pointer(&manager)))
+	if err := hresultError(r0); err != nil {
+		return err
+	}
+	defer comReleaseObject(manager)
+	return fn(manager)
+}
+
+// forEachEnum calls fn with each object of a COM enumerator, until fn returns
+// false, and releases the objects and the enumerator.
+func forEachEnum(enum unsafe.Pointer, fn func(object unsafe.Pointer) bool) error {
+	defer comReleaseObject(enum)
+	for {
+		var object unsafe.Pointer
+		var fetched uint32
+		r0, _, _ := syscall.SyscallN(comMethod(enum, enumNext), uintptr(enum), 1, uintptr(unsafe.Pointer(&object)), uintptr(unsafe.Pointer(&fetched)))
+		if err := hresultError(r0); err != nil {
+			return err
+		}
+		if fetched == 0 {
+			return nil
+		}
+		more := fn(object)
+		comReleaseObject(object)
+		if !more {
+			return nil
+		}
+	}
+}
+
+// withAdapterNetwork calls fn with the INetwork that the adapter is connected
+// to.
+func (wintun *Adapter) withAdapterNetwork(fn func(network unsafe.Pointer) error) error {
+	guid, err := wintun.guid()
+	if err != nil {
+		return err
+	}
+	return withNetworkListManager(func(manager unsafe.Pointer) error {
+		var enum unsafe.Pointer
+		r0, _, _ := syscall.SyscallN(comMethod(manager, networkListManagerGetNetworkConnections), uintptr(manager), uintptr(unsafe.Pointer(&enum)))
+		if err := hresultError(r0); err != nil {
+			return err
+		}
+		var network unsafe.Pointer
+		err := forEachEnum(enum, func(connection unsafe.Pointer) bool {
+			var adapterID windows.GUID
+			r0, _, _ := syscall.SyscallN(comMethod(connection, networkConnectionGetAdapterID), uintptr(connection), uintptr(unsafe.Pointer(&adapterID)))
+			if hresultError(r0) != nil || adapterID != guid {
+				return true
+			}
+			r0, _, _ = syscall.SyscallN(comMethod(connection, networkConnectionGetNetwork), uintptr(connection), uintptr(unsafe.Pointer(&network)))
+			if hresultError(r0) != nil {
+				network = nil
+			}
+			return false
+		})
+		if err != nil {
+			return err
+		}
+		if network == nil {
+			return windows.ERROR_NOT_FOUND
+		}
+		defer comReleaseObject(network)
+		return fn(network)
+	})
+}
+
+// connectedNetworkIDs returns the IDs, which are also the profile GUIDs, of
+// the networks the system is connected to.
+func connectedNetworkIDs() (ids []windows.GUID, err error) {
+	const NLM_ENUM_NETWORK_CONNECTED = 1
+	err = withNetworkListManager(func(manager unsafe.Pointer) error {
+		var enum unsafe.Pointer
+		r0, _, _ := syscall.SyscallN(comMethod(manager, networkListManagerGetNetworks), uintptr(manager), NLM_ENUM_NETWORK_CONNECTED, uintptr(unsafe.Pointer(&enum)))
+		if err := hresultError(r0); err != nil {
+			return err
+		}
+		var err error
+		forEachErr := forEachEnum(enum, func(network unsafe.Pointer) bool {
+			var id windows.GUID
+			r0, _, _ := syscall.SyscallN(comMethod(network, networkGetNetworkID), uintptr(network), uintptr(unsafe.Pointer(&id)))
+			if err = hresultError(r0); err != nil {
+				return false
+			}
+			ids = append(ids, id)
+			return true
+		})
+		if forEachErr != nil {
+			return forEachErr
+		}
+		return err
+	})
+	return
+}
+
+// FirewallProfile is the network category that decides which Windows Firewall
+// profile applies to a network.
+type FirewallProfile uint32
+
+const (
+	FirewallProfilePublic  FirewallProfile = 0
+	FirewallProfilePrivate FirewallProfile = 1
+	FirewallProfileDomain  FirewallProfile = 2
+)
+
+// SetFirewallProfile sets the category of the network the adapter is connected
+// to, and thereby the Windows Firewall profile, and the rules scoped to it,
+// that apply to the adapter's traffic. The adapter must be connected, which for
+// Wintun means a session is running. Windows assigns FirewallProfileDomain by
+// itself to networks on which it can reach a domain controller, so setting it
+// fails.
+func (wintun *Adapter) SetFirewallProfile(profile FirewallProfile) error {
+	return wintun.withAdapterNetwork(func(network unsafe.Pointer) error {
+		r0, _, _ := syscall.SyscallN(comMethod(network, networkSetCategory), uintptr(network), uintptr(profile))
+		return hresultError(r0)
+	})
+}
+
+// FirewallProfile returns the category of the network the adapter is
+// connected to.
+func (wintun *Adapter) FirewallProfile() (profile FirewallProfile, err error) {
+	err = wintun.withAdapterNetwork(func(network unsafe.Pointer) error {
+		r0, _, _ := syscall.SyscallN(comMethod(network, networkGetCategory), uintptr(network), uintptr(unsafe.Pointer(&profile)))
+		return hresultError(r0)
+	})
+	return
+}
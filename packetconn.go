@@ -0,0 +1,240 @@
+//go:build windows
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package wintun
+
+import (
+	"context"
+	"math"
+	"net"
+	"net/netip"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sys/windows"
+)
+
+// TunnelAddr is the net.Addr of packets read from and written to the
+// net.PacketConn returned by AsNetPacketConn. Source and Destination are those
+// of the IP header, and are invalid if the packet is not an IP packet.
+type TunnelAddr struct {
+	Source      netip.Addr
+	Destination netip.Addr
+}
+
+func (addr TunnelAddr) Network() string { return "wintun" }
+
+func (addr TunnelAddr) String() string {
+	return addr.Source.String() + "->" + addr.Destination.String()
+}
+
+// packetConn implements net.PacketConn on top of a session.
+type packetConn struct {
+	session       Session
+	writeDeadline int64 // Unix nanoseconds, 0 for none, accessed atomically
+	closeOnce     sync.Once
+
+	// SetReadDeadline sets wake, a manual-reset event, to wake up waiting
+	// ReadFrom calls; the last of them to stop waiting resets it. A ReadFrom
+	// only waits if the generation has not changed since it read the
+	// deadline, so that it cannot miss a wake-up.
+	readMu       sync.Mutex
+	readDeadline int64 // Unix nanoseconds, 0 for none
+	readGen      uint64
+	readWaiters  int
+	closing      bool
+	wake         windows.Handle
+}
+
+// AsNetPacketConn returns a net.PacketConn whose ReadFrom and WriteTo receive
+// and send raw IP packets on the session, so that code written against
+// net.PacketConn can drive the adapter. ReadFrom copies each packet straight
+// from the ring into the caller's buffer, and returns a TunnelAddr with the
+// packet's addresses; as with UDP, the part of a packet that does not fit is
+// discarded. WriteTo sends a copy of the packet as SendContext does, applying
+// the send transforms, ignores its address, since the packet carries its own,
+// and waits for room when the ring is full. Closing the connection ends the
+// session.
+func (session Session) AsNetPacketConn() net.PacketConn {
+	conn := &packetConn{session: session}
+	// Without the event, which is unlikely, changing the read deadline only
+	// affects the ReadFrom calls that start waiting afterwards.
+	conn.wake, _ = windows.CreateEvent(nil, 1, 0, nil)
+	runtime.SetFinalizer(conn, (*packetConn).closeWake)
+	return conn
+}
+
+func (conn *packetConn) closeWake() {
+	conn.readMu.Lock()
+	defer conn.readMu.Unlock()
+	if conn.wake != 0 {
+		windows.CloseHandle(conn.wake)
+		conn.wake = 0
+	}
+}
+
+func deadlineTimeout(deadline int64) (uint32, bool) {
+	if deadline == 0 {
+		return windows.INFINITE, true
+	}
+	remaining := time.Until(time.Unix(0, deadline))
+	if remaining <= 0 {
+		return 0, false
+	}
+	if remaining.Milliseconds() >= math.MaxUint32 {
+		return windows.INFINITE - 1, true
+	}
+	return uint32((remaining + time.Millisecond - 1) / time.Millisecond), true
+}
+
+func (conn *packetConn) ReadFrom(p []byte) (n int, addr net.Addr, err error) {
+	// Holding the session keeps the read event valid while waiting on it; End
+	// signals the event to wake up the wait.
+	if !conn.session.state.acquire() {
+		return 0, nil, conn.opError("read", net.ErrClosed)
+	}
+	defer conn.session.state.release()
+	handles := [2]windows.Handle{conn.session.ReadWaitEvent(), conn.wake}
+	waitCount := 2
+	if conn.wake == 0 {
+		waitCount = 1
+	}
+	for {
+		conn.readMu.Lock()
+		gen, deadline := conn.readGen, conn.readDeadline
+		conn.readMu.Unlock()
+		packet, ring, err := conn.session.receiveTransformed()
+		switch err {
+		case nil:
+			n = copy(p, packet)
+			source, destination, _ := packetAddrs(packet)
+			conn.session.ReleaseReceivePacket(ring)
+			return n, TunnelAddr{source, destination}, nil
+		case windows.ERROR_NO_MORE_ITEMS:
+			timeout, ok := deadlineTimeout(deadline)
+			if !ok {
+				return 0, nil, conn.opError("read", os.ErrDeadlineExceeded)
+			}
+			if atomic.LoadInt32(&conn.session.state.ending) != 0 {
+				return 0, nil, conn.opError("read", net.ErrClosed)
+			}
+			conn.readMu.Lock()
+			if conn.closing {
+				conn.readMu.Unlock()
+				return 0, nil, conn.opError("read", net.ErrClosed)
+			}
+			if conn.readGen != gen {
+				conn.readMu.Unlock()
+				continue
+			}
+			conn.readWaiters++
+			conn.readMu.Unlock()
+			windows.WaitForMultipleObjects(handles[:waitCount], false, timeout)
+			conn.readMu.Lock()
+			conn.readWaiters--
+			if conn.readWaiters == 0 && conn.wake != 0 {
+				windows.ResetEvent(conn.wake)
+			}
+			conn.readMu.Unlock()
+		case ErrSessionEnded:
+			return 0, nil, conn.opError("read", net.ErrClosed)
+		default:
+			return 0, nil, conn.opError("read", err)
+		}
+	}
+}
+
+func (conn *packetConn) WriteTo(p []byte, addr net.Addr) (n int, err error) {
+	ctx := context.Background()
+	if deadline := atomic.LoadInt64(&conn.writeDeadline); deadline != 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, time.Unix(0, deadline))
+		defer cancel()
+	}
+	packet := p
+	state := conn.session.state
+	state.transformsMu.RLock()
+	transformed := len(state.sendTransforms) != 0
+	state.transformsMu.RUnlock()
+	if transformed && len(p) <= PacketSizeMax {
+		// Transforms may rewrite the packet in place, but WriteTo must not
+		// modify p.
+		buf := receivePool.Get().(*[PacketSizeMax]byte)
+		defer receivePool.Put(buf)
+		packet = buf[:copy(buf[:], p)]
+	}
+	switch err := conn.session.SendContext(ctx, packet); err {
+	case nil:
+		return len(p), nil
+	case context.DeadlineExceeded:
+		return 0, conn.opError("write", os.ErrDeadlineExceeded)
+	case ErrSessionEnded:
+		return 0, conn.opError("write", net.ErrClosed)
+	default:
+		return 0, conn.opError("write", err)
+	}
+}
+
+func (conn *packetConn) opError(op string, err error) error {
+	return &net.OpError{Op: op, Net: "wintun", Addr: conn.LocalAddr(), Err: err}
+}
+
+func (conn *packetConn) Close() error {
+	// Wake up every waiting ReadFrom, rather than the single one that ending
+	// the session would wake up at once.
+	conn.readMu.Lock()
+	conn.closing = true
+	if conn.wake != 0 {
+		windows.SetEvent(conn.wake)
+	}
+	conn.readMu.Unlock()
+	conn.session.End()
+	// End waits for ReadFrom calls to return, so none waits on wake anymore.
+	conn.closeOnce.Do(func() {
+		runtime.SetFinalizer(conn, nil)
+		conn.closeWake()
+	})
+	return nil
+}
+
+func (conn *packetConn) LocalAddr() net.Addr {
+	return TunnelAddr{}
+}
+
+func unixDeadline(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+func (conn *packetConn) SetDeadline(t time.Time) error {
+	conn.SetReadDeadline(t)
+	return conn.SetWriteDeadline(t)
+}
+
+// SetReadDeadline sets the deadline of ReadFrom calls, including those already
+// waiting, which it wakes up to check it, so that a deadline in the past
+// unblocks them.
+func (conn *packetConn) SetReadDeadline(t time.Time) error {
+	conn.readMu.Lock()
+	defer conn.readMu.Unlock()
+	conn.readDeadline = unixDeadline(t)
+	conn.readGen++
+	if conn.wake != 0 {
+		windows.SetEvent(conn.wake)
+	}
+	return nil
+}
+
+func (conn *packetConn) SetWriteDeadline(t time.Time) error {
+	atomic.StoreInt64(&conn.writeDeadline, unixDeadline(t))
+	return nil
+}
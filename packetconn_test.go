@@ -0,0 +1,81 @@
+//go:build windows
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package wintun
+
+import (
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestPacketConnSetReadDeadlineWakesReader(t *testing.T) {
+	wintun := createTestAdapter(t, testAdapterName, nil)
+	session, err := wintun.StartSession(RingCapacityMin)
+	if err != nil {
+		t.Fatalf("StartSession failed: %v", err)
+	}
+	session.SetReceiveFilter([]uint8{})
+	conn := session.AsNetPacketConn()
+	defer conn.Close()
+	done := make(chan error, 1)
+	go func() {
+		_, _, err := conn.ReadFrom(make([]byte, PacketSizeMax))
+		done <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	conn.SetReadDeadline(time.Now())
+	select {
+	case err := <-done:
+		if !errors.Is(err, os.ErrDeadlineExceeded) {
+			t.Fatalf("ReadFrom returned %v, want os.ErrDeadlineExceeded", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("ReadFrom still waiting 5s after SetReadDeadline")
+	}
+}
+
+func TestPacketConnCloseWakesReaders(t *testing.T) {
+	wintun := createTestAdapter(t, testAdapterName, nil)
+	session, err := wintun.StartSession(RingCapacityMin)
+	if err != nil {
+		t.Fatalf("StartSession failed: %v", err)
+	}
+	session.SetReceiveFilter([]uint8{})
+	conn := session.AsNetPacketConn()
+	const readers = 2
+	done := make(chan error, readers)
+	for i := 0; i < readers; i++ {
+		go func() {
+			_, _, err := conn.ReadFrom(make([]byte, PacketSizeMax))
+			done <- err
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	closed := make(chan struct{})
+	go func() {
+		conn.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close still waiting 5s after being called")
+	}
+	for i := 0; i < readers; i++ {
+		select {
+		case err := <-done:
+			if !errors.Is(err, net.ErrClosed) {
+				t.Errorf("ReadFrom returned %v, want net.ErrClosed", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("ReadFrom still waiting 5s after Close")
+		}
+	}
+}
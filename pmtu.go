@@ -0,0 +1,89 @@
+//go:build windows
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package wintun
+
+import (
+	"errors"
+	"net/netip"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// ErrNotRoutedThroughAdapter is returned when an operation concerning the
+// adapter is given a destination whose best route goes elsewhere.
+var ErrNotRoutedThroughAdapter = errors.New("Destination is not routed through the adapter")
+
+// ProbePathMTU finds the largest IPv4 packet that reaches dst through the
+// adapter without fragmentation, by sending ICMP echo requests with the
+// don't-fragment flag set and binary searching on their size, up to the
+// adapter's configured MTU. Each probe waits at most timeout for its reply, so
+// the probe only works if dst answers pings and the replies make it back; if
+// even the smallest probe is not answered, the error of that probe is
+// returned. IPv6 destinations are not supported, since IPv6 has no
+// don't-fragment flag to probe with.
+func (wintun *Adapter) ProbePathMTU(dst netip.Addr, timeout time.Duration) (int, error) {
+	const (
+		IP_FLAG_DF = 0x2
+		headerSize = 20 + 8 // IPv4 and ICMP headers
+		minimumMTU = 68
+	)
+	dst = dst.Unmap()
+	if !dst.Is4() {
+		return 0, windows.ERROR_NOT_SUPPORTED
+	}
+	routed, err := wintun.RoutesAddress(dst)
+	if err != nil {
+		return 0, err
+	}
+	if !routed {
+		return 0, ErrNotRoutedThroughAdapter
+	}
+	row, err := wintun.ipInterface(windows.AF_INET)
+	if err != nil {
+		return 0, err
+	}
+	handle, err := icmpCreateFile()
+	if err != nil {
+		return 0, err
+	}
+	defer icmpCloseHandle(handle)
+
+	maximumMTU := int(row.NLMTU)
+	if maximumMTU < minimumMTU {
+		return 0, windows.ERROR_INVALID_PARAMETER
+	}
+	data := make([]byte, maximumMTU-headerSize)
+	reply := make([]byte, int(unsafe.Sizeof(icmpEchoReply{}))+len(data)+8)
+	options := ipOptionInformation{TTL: 128, Flags: IP_FLAG_DF}
+	probe := func(mtu int) error {
+		_, err := icmpSendEcho(handle, dst.As4(), data[:mtu-headerSize], &options, reply, uint32(timeout/time.Millisecond))
+		if err != nil {
+			return err
+		}
+		if status := (*icmpEchoReply)(unsafe.Pointer(&reply[0])).Status; status != 0 {
+			return windows.Errno(status)
+		}
+		return nil
+	}
+	err = probe(minimumMTU)
+	if err != nil {
+		return 0, err
+	}
+	low, high := minimumMTU, maximumMTU
+	for low < high {
+		mtu := (low + high + 1) / 2
+		if probe(mtu) == nil {
+			low = mtu
+		} else {
+			high = mtu - 1
+		}
+	}
+	return low, nil
+}
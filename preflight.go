@@ -0,0 +1,43 @@
+//go:build windows
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package wintun
+
+// Preflight checks, before anything is changed on the system, that this
+// process can create adapters: that it holds the privilege to load the driver,
+// and that wintun.dll loads. If RequireDLLSignature was called, it also checks
+// the signature of the file wintun.dll was loaded from, even if it was loaded
+// before RequireDLLSignature was called. It returns the first problem found,
+// such as ErrMissingDriverPrivilege or a *LoadError.
+func Preflight() error {
+	ok, err := HasDriverPrivilege()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrMissingDriverPrivilege
+	}
+	err = modwintun.Load()
+	if err != nil {
+		return err
+	}
+	modwintun.mu.Lock()
+	verify := modwintun.verify
+	modwintun.mu.Unlock()
+	if verify == nil {
+		return nil
+	}
+	path, err := modwintun.path()
+	if err != nil {
+		return err
+	}
+	err = verify(path)
+	if err != nil {
+		return &LoadError{path, LoadErrorSignature, err}
+	}
+	return nil
+}
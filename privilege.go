@@ -0,0 +1,55 @@
+//go:build windows
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package wintun
+
+import (
+	"errors"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// ErrMissingDriverPrivilege is returned by driver operations when the process
+// token lacks SeLoadDriverPrivilege. Elevated administrators normally hold it,
+// so this usually means the process runs under a restricted account or a
+// token from which the privilege was removed.
+var ErrMissingDriverPrivilege = errors.New("Process token lacks SeLoadDriverPrivilege")
+
+// HasDriverPrivilege reports whether the process token holds
+// SeLoadDriverPrivilege, which loading and unloading the driver requires. A
+// privilege that is held but not enabled counts as held, since it can be
+// enabled when needed.
+func HasDriverPrivilege() (bool, error) {
+	var privilege windows.LUID
+	err := windows.LookupPrivilegeValue(nil, windows.StringToUTF16Ptr("SeLoadDriverPrivilege"), &privilege)
+	if err != nil {
+		return false, err
+	}
+	var token windows.Token
+	err = windows.OpenProcessToken(windows.CurrentProcess(), windows.TOKEN_QUERY, &token)
+	if err != nil {
+		return false, err
+	}
+	defer token.Close()
+	var size uint32
+	err = windows.GetTokenInformation(token, windows.TokenPrivileges, nil, 0, &size)
+	if err != windows.ERROR_INSUFFICIENT_BUFFER {
+		return false, err
+	}
+	buf := make([]byte, size)
+	err = windows.GetTokenInformation(token, windows.TokenPrivileges, &buf[0], size, &size)
+	if err != nil {
+		return false, err
+	}
+	for _, p := range (*windows.Tokenprivileges)(unsafe.Pointer(&buf[0])).AllPrivileges() {
+		if p.Luid == privilege && p.Attributes&windows.SE_PRIVILEGE_REMOVED == 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,127 @@
+//go:build windows
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package wintun
+
+import (
+	"encoding/binary"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/registry"
+)
+
+const (
+	networkListKeyPath       = `SOFTWARE\Microsoft\Windows NT\CurrentVersion\NetworkList`
+	wintunDefaultDescription = "Wintun Userspace Tunnel"
+)
+
+// wintunDescriptions returns the device descriptions of the installed Wintun
+// adapters, which Network Location Awareness records in the signatures of the
+// networks seen on them.
+func wintunDescriptions() map[string]bool {
+	descriptions := map[string]bool{wintunDefaultDescription: true}
+	classKey, err := registry.OpenKey(registry.LOCAL_MACHINE, netClassKeyPath, registry.ENUMERATE_SUB_KEYS)
+	if err != nil {
+		return descriptions
+	}
+	defer classKey.Close()
+	names, err := classKey.ReadSubKeyNames(-1)
+	if err != nil {
+		return descriptions
+	}
+	for _, name := range names {
+		key, err := registry.OpenKey(classKey, name, registry.QUERY_VALUE)
+		if err != nil {
+			continue
+		}
+		componentID, _, err := key.GetStringValue("ComponentId")
+		description, _, err2 := key.GetStringValue("DriverDesc")
+		key.Close()
+		if err == nil && err2 == nil && strings.EqualFold(componentID, "wintun") {
+			descriptions[description] = true
+		}
+	}
+	return descriptions
+}
+
+// systemtimeValue parses a SYSTEMTIME, in local time, stored as a binary value.
+func systemtimeValue(key registry.Key, name string) (time.Time, bool) {
+	value, _, err := key.GetBinaryValue(name)
+	if err != nil || len(value) < 16 {
+		return time.Time{}, false
+	}
+	field := func(i int) int { return int(binary.LittleEndian.Uint16(value[i*2:])) }
+	return time.Date(field(0), time.Month(field(1)), field(3), field(4), field(5), field(6), field(7)*int(time.Millisecond), time.Local), true
+}
+
+// CleanupStaleNetworkProfiles removes the Network Location Awareness profiles
+// of networks seen on Wintun adapters that have not been connected for at least
+// olderThan, and returns how many it removed. Each adapter created with a new
+// random GUID makes Windows record a new profile, such as "Network 12", and
+// these otherwise accumulate forever. To leave unrelated profiles alone, a
+// profile is only removed if its signature names a Wintun adapter as the
+// device the network was seen on, it has a valid last connection time, and its
+// network is not currently connected.
+func CleanupStaleNetworkProfiles(olderThan time.Duration) (int, error) {
+	connected, err := connectedNetworkIDs()
+	if err != nil {
+		return 0, err
+	}
+	isConnected := make(map[string]bool, len(connected))
+	for _, id := range connected {
+		isConnected[strings.ToUpper(id.String())] = true
+	}
+	descriptions := wintunDescriptions()
+	signatures, err := registry.OpenKey(registry.LOCAL_MACHINE, networkListKeyPath+`\Signatures\Unmanaged`, registry.ENUMERATE_SUB_KEYS)
+	if err != nil {
+		return 0, err
+	}
+	defer signatures.Close()
+	names, err := signatures.ReadSubKeyNames(-1)
+	if err != nil {
+		return 0, err
+	}
+	cutoff := time.Now().Add(-olderThan)
+	removed := 0
+	for _, name := range names {
+		signature, err := registry.OpenKey(signatures, name, registry.QUERY_VALUE)
+		if err != nil {
+			continue
+		}
+		description, _, err := signature.GetStringValue("Description")
+		profileGUID, _, err2 := signature.GetStringValue("ProfileGuid")
+		signature.Close()
+		if err != nil || err2 != nil || !descriptions[description] {
+			continue
+		}
+		if _, err := windows.GUIDFromString(profileGUID); err != nil || isConnected[strings.ToUpper(profileGUID)] {
+			continue
+		}
+		profilePath := networkListKeyPath + `\Profiles\` + profileGUID
+		profile, err := registry.OpenKey(registry.LOCAL_MACHINE, profilePath, registry.QUERY_VALUE)
+		if err != nil {
+			continue
+		}
+		lastConnected, ok := systemtimeValue(profile, "DateLastConnected")
+		profile.Close()
+		if !ok || lastConnected.After(cutoff) {
+			continue
+		}
+		err = registry.DeleteKey(registry.LOCAL_MACHINE, profilePath)
+		if err != nil {
+			return removed, err
+		}
+		err = registry.DeleteKey(signatures, name)
+		if err != nil && err != registry.ErrNotExist {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
@@ -0,0 +1,187 @@
+//go:build windows
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package wintun
+
+import (
+	"strings"
+	"time"
+
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/registry"
+)
+
+const (
+	netClassKeyPath        = `SYSTEM\CurrentControlSet\Control\Class\{4d36e972-e325-11ce-bfc1-08002be10318}`
+	netServiceClassKeyPath = `SYSTEM\CurrentControlSet\Control\Class\{4d36e974-e325-11ce-bfc1-08002be10318}`
+)
+
+// classKey opens the adapter's device key under the network adapter class.
+func (wintun *Adapter) classKey(access uint32) (registry.Key, error) {
+	guid, err := wintun.guid()
+	if err != nil {
+		return 0, err
+	}
+	classKey, err := registry.OpenKey(registry.LOCAL_MACHINE, netClassKeyPath, registry.ENUMERATE_SUB_KEYS)
+	if err != nil {
+		return 0, err
+	}
+	defer classKey.Close()
+	names, err := classKey.ReadSubKeyNames(-1)
+	if err != nil {
+		return 0, err
+	}
+	for _, name := range names {
+		key, err := registry.OpenKey(classKey, name, access|registry.QUERY_VALUE)
+		if err != nil {
+			continue
+		}
+		instanceID, _, err := key.GetStringValue("NetCfgInstanceId")
+		if err == nil && strings.EqualFold(instanceID, guid.String()) {
+			return key, nil
+		}
+		key.Close()
+	}
+	return 0, windows.ERROR_NOT_FOUND
+}
+
+// wintunLUIDs returns the LUIDs of all Wintun adapters present on the system,
+// including ones created by other processes.
+func wintunLUIDs() ([]uint64, error) {
+	classKey, err := registry.OpenKey(registry.LOCAL_MACHINE, netClassKeyPath, registry.ENUMERATE_SUB_KEYS)
+	if err != nil {
+		return nil, err
+	}
+	defer classKey.Close()
+	names, err := classKey.ReadSubKeyNames(-1)
+	if err != nil {
+		return nil, err
+	}
+	var luids []uint64
+	for _, name := range names {
+		key, err := registry.OpenKey(classKey, name, registry.QUERY_VALUE)
+		if err != nil {
+			continue
+		}
+		componentID, _, err := key.GetStringValue("ComponentId")
+		instanceID, _, err2 := key.GetStringValue("NetCfgInstanceId")
+		key.Close()
+		if err != nil || err2 != nil || !strings.EqualFold(componentID, "wintun") {
+			continue
+		}
+		guid, err := windows.GUIDFromString(instanceID)
+		if err != nil {
+			continue
+		}
+		var luid uint64
+		if convertInterfaceGUIDToLUID(&guid, &luid) == nil {
+			luids = append(luids, luid)
+		}
+	}
+	return luids, nil
+}
+
+// CreatedAt returns the time the adapter was installed, read from the
+// NetworkInterfaceInstallTimestamp value of its device key. On versions of
+// Windows that do not record that value, the last write time of the device key
+// is returned instead, which is usually but not always the creation time.
+func (wintun *Adapter) CreatedAt() (time.Time, error) {
+	key, err := wintun.classKey(registry.QUERY_VALUE)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer key.Close()
+	timestamp, _, err := key.GetIntegerValue("NetworkInterfaceInstallTimestamp")
+	if err == nil {
+		return FiletimeToTime(uint32(timestamp), uint32(timestamp>>32)), nil
+	} else if err != registry.ErrNotExist {
+		return time.Time{}, err
+	}
+	info, err := key.Stat()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.ModTime(), nil
+}
+
+// DeviceInstanceID returns the Plug and Play device instance ID of the
+// adapter, such as SWD\WINTUN\{GUID}, which device management tools use to
+// address the device. It is asked from SetupAPI for the network adapter whose
+// NetCfgInstanceId is the adapter's GUID.
+func (wintun *Adapter) DeviceInstanceID() (string, error) {
+	guid, err := wintun.guid()
+	if err != nil {
+		return "", err
+	}
+	return netDeviceInstanceID(guid)
+}
+
+// BoundFilters returns the NDIS lightweight filters bound to the adapter, in
+// binding order, as read from the FilterList value of the Linkage subkey of
+// its device key. Each filter is identified by the component ID of its driver,
+// such as ms_wfplwf_lower, or by its GUID if that cannot be found.
+func (wintun *Adapter) BoundFilters() ([]string, error) {
+	key, err := wintun.classKey(registry.QUERY_VALUE)
+	if err != nil {
+		return nil, err
+	}
+	defer key.Close()
+	linkage, err := registry.OpenKey(key, "Linkage", registry.QUERY_VALUE)
+	if err != nil {
+		return nil, err
+	}
+	defer linkage.Close()
+	entries, _, err := linkage.GetStringsValue("FilterList")
+	if err == registry.ErrNotExist {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	componentIDs := filterComponentIDs()
+	filters := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		// Entries take the form {adapter GUID}-{filter GUID}-index.
+		parts := strings.SplitN(entry, "}-{", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		filter := "{" + strings.SplitN(parts[1], "}", 2)[0] + "}"
+		if componentID, ok := componentIDs[strings.ToLower(filter)]; ok {
+			filter = componentID
+		}
+		filters = append(filters, filter)
+	}
+	return filters, nil
+}
+
+// filterComponentIDs maps the lowercase instance GUIDs of the installed
+// network services, which include filter drivers, to their component IDs.
+func filterComponentIDs() map[string]string {
+	componentIDs := make(map[string]string)
+	classKey, err := registry.OpenKey(registry.LOCAL_MACHINE, netServiceClassKeyPath, registry.ENUMERATE_SUB_KEYS)
+	if err != nil {
+		return componentIDs
+	}
+	defer classKey.Close()
+	names, err := classKey.ReadSubKeyNames(-1)
+	if err != nil {
+		return componentIDs
+	}
+	for _, name := range names {
+		key, err := registry.OpenKey(classKey, name, registry.QUERY_VALUE)
+		if err != nil {
+			continue
+		}
+		componentID, _, err := key.GetStringValue("ComponentId")
+		instanceID, _, err2 := key.GetStringValue("NetCfgInstanceId")
+		key.Close()
+		if err == nil && err2 == nil {
+			componentIDs[strings.ToLower(instanceID)] = componentID
+		}
+	}
+	return componentIDs
+}
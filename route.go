@@ -0,0 +1,281 @@
+//go:build windows
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package wintun
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"sort"
+
+	"golang.org/x/sys/windows"
+)
+
+// RouteEntry is a route through an adapter. An invalid NextHop means the
+// destination is on-link.
+type RouteEntry struct {
+	Destination netip.Prefix
+	NextHop     netip.Addr
+	Metric      uint32
+}
+
+// AddRoute adds a route through the adapter.
+func (wintun *Adapter) AddRoute(route RouteEntry) error {
+	if !route.Destination.IsValid() {
+		return windows.ERROR_INVALID_PARAMETER
+	}
+	destination := route.Destination.Masked()
+	nextHop := route.NextHop
+	if !nextHop.IsValid() {
+		if destination.Addr().Is4() {
+			nextHop = netip.IPv4Unspecified()
+		} else {
+			nextHop = netip.IPv6Unspecified()
+		}
+	} else if nextHop.Unmap().Is4() != destination.Addr().Is4() {
+		return windows.ERROR_INVALID_PARAMETER
+	}
+	var row mibIPforwardRow2
+	initializeIPForwardEntry(&row)
+	row.InterfaceLUID = wintun.LUID()
+	err := row.DestinationPrefix.Prefix.setAddr(destination.Addr())
+	if err != nil {
+		return err
+	}
+	row.DestinationPrefix.PrefixLength = uint8(destination.Bits())
+	err = row.NextHop.setAddr(nextHop)
+	if err != nil {
+		return err
+	}
+	row.Metric = route.Metric
+	if skipDryRun("add route to %v via %v with metric %d on interface %d", destination, nextHop, route.Metric, row.InterfaceLUID) {
+		return nil
+	}
+	return createIPForwardEntry2(&row)
+}
+
+// AddRoutes adds routes through the adapter, stopping at the first failure.
+func (wintun *Adapter) AddRoutes(routes []RouteEntry) error {
+	for _, route := range routes {
+		err := wintun.AddRoute(route)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RoutesAddress reports whether the best route to dst, as chosen by the
+// system's routing table, goes through this adapter.
+func (wintun *Adapter) RoutesAddress(dst netip.Addr) (bool, error) {
+	var destination, bestSource rawSockaddrInet
+	err := destination.setAddr(dst)
+	if err != nil {
+		return false, err
+	}
+	var bestRoute mibIPforwardRow2
+	err = getBestRoute2(nil, 0, nil, &destination, 0, &bestRoute, &bestSource)
+	if err != nil {
+		return false, err
+	}
+	return bestRoute.InterfaceLUID == wintun.LUID(), nil
+}
+
+// SplitTunnelRoutes computes the smallest set of on-link routes that sends the
+// include networks through the tunnel while leaving the exclude networks to
+// the rest of the routing table. Default routes are split into two halves, such
+// as 0.0.0.0/1 and 128.0.0.0/1, so that they take precedence over the existing
+// default route without replacing it. The result can be passed to AddRoutes.
+func SplitTunnelRoutes(include []netip.Prefix, exclude []netip.Prefix) ([]RouteEntry, error) {
+	var prefixes []netip.Prefix
+	for _, prefix := range include {
+		if !prefix.IsValid() {
+			return nil, windows.ERROR_INVALID_PARAMETER
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	for _, excluded := range exclude {
+		if !excluded.IsValid() {
+			return nil, windows.ERROR_INVALID_PARAMETER
+		}
+		excluded = excluded.Masked()
+		var remaining []netip.Prefix
+		for _, prefix := range prefixes {
+			remaining = subtractPrefix(remaining, prefix, excluded)
+		}
+		prefixes = remaining
+	}
+	prefixes = mergePrefixes(prefixes)
+	routes := make([]RouteEntry, 0, len(prefixes)+2)
+	for _, prefix := range prefixes {
+		if prefix.Bits() == 0 {
+			low, high := splitPrefix(prefix)
+			routes = append(routes, RouteEntry{Destination: low}, RouteEntry{Destination: high})
+		} else {
+			routes = append(routes, RouteEntry{Destination: prefix})
+		}
+	}
+	return routes, nil
+}
+
+// subtractPrefix appends to dst the prefixes covering prefix but not excluded.
+func subtractPrefix(dst []netip.Prefix, prefix, excluded netip.Prefix) []netip.Prefix {
+	if !prefix.Overlaps(excluded) {
+		return append(dst, prefix)
+	}
+	if excluded.Bits() <= prefix.Bits() {
+		return dst
+	}
+	low, high := splitPrefix(prefix)
+	dst = subtractPrefix(dst, low, excluded)
+	return subtractPrefix(dst, high, excluded)
+}
+
+// splitPrefix returns the two halves of prefix, which must be masked and
+// shorter than a host prefix.
+func splitPrefix(prefix netip.Prefix) (low, high netip.Prefix) {
+	bits := prefix.Bits()
+	low = netip.PrefixFrom(prefix.Addr(), bits+1)
+	addr := prefix.Addr().AsSlice()
+	addr[bits/8] |= 0x80 >> (bits % 8)
+	highAddr, _ := netip.AddrFromSlice(addr)
+	high = netip.PrefixFrom(highAddr, bits+1)
+	return
+}
+
+// mergePrefixes sorts prefixes, drops those contained in others and joins
+// sibling pairs into their parent.
+func mergePrefixes(prefixes []netip.Prefix) []netip.Prefix {
+	for {
+		sort.Slice(prefixes, func(i, j int) bool {
+			if c := prefixes[i].Addr().Compare(prefixes[j].Addr()); c != 0 {
+				return c < 0
+			}
+			return prefixes[i].Bits() < prefixes[j].Bits()
+		})
+		merged := prefixes[:0:0]
+		changed := false
+		for _, prefix := range prefixes {
+			if len(merged) > 0 {
+				last := merged[len(merged)-1]
+				if last.Contains(prefix.Addr()) && last.Bits() <= prefix.Bits() {
+					changed = changed || last != prefix
+					continue
+				}
+				if last.Bits() == prefix.Bits() && last.Bits() > 0 {
+					parent := netip.PrefixFrom(last.Addr(), last.Bits()-1).Masked()
+					if parent.Contains(prefix.Addr()) {
+						merged[len(merged)-1] = parent
+						changed = true
+						continue
+					}
+				}
+			}
+			merged = append(merged, prefix)
+		}
+		prefixes = merged
+		if !changed {
+			return prefixes
+		}
+	}
+}
+
+// SetDefaultGateway makes the adapter the default gateway for the address
+// family of nextHop, which may be the unspecified address for an on-link
+// gateway. It disables the automatic interface metric, setting the interface
+// metric to zero, and adds a default route through nextHop with metric, so
+// that the adapter's default route takes precedence over the existing one,
+// which is kept. To avoid routing the tunnel's own traffic into itself, the
+// caller must add a host route to the tunnel endpoint through the physical
+// interface beforehand.
+func (wintun *Adapter) SetDefaultGateway(nextHop netip.Addr, metric uint32) error {
+	nextHop = nextHop.Unmap()
+	var family uint16
+	var destination netip.Prefix
+	switch {
+	case nextHop.Is4():
+		family = windows.AF_INET
+		destination = netip.PrefixFrom(netip.IPv4Unspecified(), 0)
+	case nextHop.Is6():
+		family = windows.AF_INET6
+		destination = netip.PrefixFrom(netip.IPv6Unspecified(), 0)
+	default:
+		return windows.ERROR_INVALID_PARAMETER
+	}
+	row, err := wintun.ipInterface(family)
+	if err != nil {
+		return err
+	}
+	row.UseAutomaticMetric = false
+	row.Metric = 0
+	err = setIPInterface(row)
+	if err != nil {
+		return err
+	}
+	err = wintun.AddRoute(RouteEntry{Destination: destination, NextHop: nextHop, Metric: metric})
+	if err == windows.ERROR_OBJECT_ALREADY_EXISTS {
+		return nil
+	}
+	return err
+}
+
+// ErrRouteConflict is matched, with errors.Is, by the *RouteConflictError that
+// CheckRouteConflicts returns.
+var ErrRouteConflict = errors.New("Route conflicts with an existing route")
+
+// RouteConflictError describes a route that conflicts with an existing one.
+type RouteConflictError struct {
+	Route         RouteEntry // The route checked
+	Existing      RouteEntry // The existing route it conflicts with
+	InterfaceLUID uint64     // The interface of the existing route
+}
+
+func (e *RouteConflictError) Error() string {
+	return fmt.Sprintf("Route to %v conflicts with existing route to %v on interface %d", e.Route.Destination, e.Existing.Destination, e.InterfaceLUID)
+}
+
+func (e *RouteConflictError) Unwrap() error {
+	return ErrRouteConflict
+}
+
+// CheckRouteConflicts checks routes, before adding them with AddRoute or
+// AddRoutes, against the system routing table. A route conflicts with an
+// existing route to the same destination, on any interface, and with a more
+// specific existing route on another interface, which would take the traffic
+// to part of the destination away from the adapter. Multicast, loopback,
+// link-local and limited broadcast routes, which every interface has, are not
+// considered. The first conflict found is returned as a *RouteConflictError.
+func (wintun *Adapter) CheckRouteConflicts(routes []RouteEntry) error {
+	table, err := getIPForwardTable2(windows.AF_UNSPEC)
+	if err != nil {
+		return err
+	}
+	luid := wintun.LUID()
+	for _, route := range routes {
+		if !route.Destination.IsValid() {
+			return windows.ERROR_INVALID_PARAMETER
+		}
+		destination := route.Destination.Masked()
+		for i := range table {
+			row := &table[i]
+			addr := row.DestinationPrefix.Prefix.addr()
+			if row.Loopback || addr.IsMulticast() || addr.IsLoopback() || addr.IsLinkLocalUnicast() || addr == netip.AddrFrom4([4]byte{255, 255, 255, 255}) {
+				continue
+			}
+			existing := netip.PrefixFrom(addr, int(row.DestinationPrefix.PrefixLength))
+			if existing == destination || existing.Bits() > destination.Bits() && destination.Contains(addr) && row.InterfaceLUID != luid {
+				return &RouteConflictError{
+					Route:         route,
+					Existing:      RouteEntry{Destination: existing, NextHop: row.NextHop.addr(), Metric: row.Metric},
+					InterfaceLUID: row.InterfaceLUID,
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+//go:build windows
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package wintun
+
+import (
+	"net/netip"
+	"strings"
+	"testing"
+
+	"golang.org/x/sys/windows"
+)
+
+func parsePrefixes(t *testing.T, s string) []netip.Prefix {
+	t.Helper()
+	var prefixes []netip.Prefix
+	for _, field := range strings.Fields(s) {
+		prefixes = append(prefixes, netip.MustParsePrefix(field))
+	}
+	return prefixes
+}
+
+func TestSplitTunnelRoutes(t *testing.T) {
+	tests := []struct {
+		name    string
+		include string
+		exclude string
+		want    string
+	}{
+		{"full tunnel", "0.0.0.0/0", "", "0.0.0.0/1 128.0.0.0/1"},
+		{"full IPv6 tunnel", "::/0", "", "::/1 8000::/1"},
+		{"single network", "10.0.0.0/8", "", "10.0.0.0/8"},
+		{"unmasked network", "10.1.2.3/8", "", "10.0.0.0/8"},
+		{"full tunnel with exception", "0.0.0.0/0", "10.0.0.0/8",
+			"0.0.0.0/5 8.0.0.0/7 11.0.0.0/8 12.0.0.0/6 16.0.0.0/4 32.0.0.0/3 64.0.0.0/2 128.0.0.0/1"},
+		{"network with host exception", "192.168.0.0/30", "192.168.0.2/32", "192.168.0.0/31 192.168.0.3/32"},
+		{"siblings merged", "10.0.0.0/9 10.128.0.0/9", "", "10.0.0.0/8"},
+		{"contained network dropped", "10.1.0.0/16 10.0.0.0/8", "", "10.0.0.0/8"},
+		{"all excluded", "10.0.0.0/8", "10.0.0.0/8", ""},
+		{"excluded by a larger network", "10.1.0.0/16", "10.0.0.0/8", ""},
+		{"unrelated exception", "10.0.0.0/8", "192.168.0.0/16 fd00::/8", "10.0.0.0/8"},
+		{"both families", "0.0.0.0/0 ::/0", "", "0.0.0.0/1 128.0.0.0/1 ::/1 8000::/1"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			routes, err := SplitTunnelRoutes(parsePrefixes(t, test.include), parsePrefixes(t, test.exclude))
+			if err != nil {
+				t.Fatalf("SplitTunnelRoutes failed: %v", err)
+			}
+			var got []string
+			for _, route := range routes {
+				if route.NextHop.IsValid() || route.Metric != 0 {
+					t.Errorf("Route %v is not an on-link route", route)
+				}
+				got = append(got, route.Destination.String())
+			}
+			if strings.Join(got, " ") != test.want {
+				t.Errorf("SplitTunnelRoutes = %q, want %q", strings.Join(got, " "), test.want)
+			}
+		})
+	}
+}
+
+func TestSplitTunnelRoutesInvalid(t *testing.T) {
+	if _, err := SplitTunnelRoutes([]netip.Prefix{{}}, nil); err != windows.ERROR_INVALID_PARAMETER {
+		t.Errorf("SplitTunnelRoutes with an invalid include returned %v, want ERROR_INVALID_PARAMETER", err)
+	}
+	if _, err := SplitTunnelRoutes(nil, []netip.Prefix{{}}); err != windows.ERROR_INVALID_PARAMETER {
+		t.Errorf("SplitTunnelRoutes with an invalid exclude returned %v, want ERROR_INVALID_PARAMETER", err)
+	}
+}
+
+func TestSetDefaultGatewayInvalid(t *testing.T) {
+	var wintun Adapter
+	if err := wintun.SetDefaultGateway(netip.Addr{}, 0); err != windows.ERROR_INVALID_PARAMETER {
+		t.Errorf("SetDefaultGateway with an invalid next hop returned %v, want ERROR_INVALID_PARAMETER", err)
+	}
+}
+
+func TestSetDefaultGateway(t *testing.T) {
+	wintun := createTestAdapter(t, testAdapterName, nil)
+	if err := wintun.SetIPAddress(netip.MustParsePrefix("198.18.0.1/24")); err != nil {
+		t.Fatalf("SetIPAddress failed: %v", err)
+	}
+	nextHop := netip.MustParseAddr("198.18.0.2")
+	// A high route metric keeps the test from taking over the default route.
+	const metric = 9000
+	for i := 0; i < 2; i++ {
+		if err := wintun.SetDefaultGateway(nextHop, metric); err != nil {
+			t.Fatalf("SetDefaultGateway call %d failed: %v", i+1, err)
+		}
+	}
+	row, err := wintun.ipInterface(windows.AF_INET)
+	if err != nil {
+		t.Fatalf("ipInterface failed: %v", err)
+	}
+	if row.UseAutomaticMetric || row.Metric != 0 {
+		t.Errorf("Interface metric is automatic %v, %d, want a fixed 0", row.UseAutomaticMetric, row.Metric)
+	}
+	config, err := wintun.ExportConfig()
+	if err != nil {
+		t.Fatalf("ExportConfig failed: %v", err)
+	}
+	want := RouteEntry{Destination: netip.MustParsePrefix("0.0.0.0/0"), NextHop: nextHop, Metric: metric}
+	found := false
+	for _, route := range config.Routes {
+		if route == want {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Routes %v lack %v", config.Routes, want)
+	}
+}
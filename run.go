@@ -0,0 +1,103 @@
+//go:build windows
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package wintun
+
+import (
+	"runtime"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	modkernel32           = windows.NewLazySystemDLL("kernel32.dll")
+	procSetThreadPriority = modkernel32.NewProc("SetThreadPriority")
+)
+
+// ThreadPriority is the scheduling priority of a thread relative to the
+// priority class of its process.
+type ThreadPriority int32
+
+const (
+	ThreadPriorityLowest       ThreadPriority = -2
+	ThreadPriorityBelowNormal  ThreadPriority = -1
+	ThreadPriorityNormal       ThreadPriority = 0
+	ThreadPriorityAboveNormal  ThreadPriority = 1
+	ThreadPriorityHighest      ThreadPriority = 2
+	ThreadPriorityTimeCritical ThreadPriority = 15
+)
+
+func setThreadPriority(thread windows.Handle, priority ThreadPriority) (err error) {
+	r1, _, e1 := syscall.Syscall(procSetThreadPriority.Addr(), 2, uintptr(thread), uintptr(priority), 0)
+	if r1 == 0 {
+		err = e1
+	}
+	return
+}
+
+// SetReadThreadPriority sets the priority of the OS thread that Run receives
+// packets on, taking effect at its next packet. Raising it lowers receive
+// latency under load, but a busy high-priority thread can starve the rest of
+// the process and system, so ThreadPriorityTimeCritical in particular should
+// only be used with a handler that never blocks. The default is
+// ThreadPriorityNormal.
+func (session Session) SetReadThreadPriority(priority ThreadPriority) {
+	atomic.StoreInt32(&session.state.priority, int32(priority))
+}
+
+// Run receives packets until the session ends, calling handler with each one,
+// as rewritten by the receive transforms, on a goroutine locked to its OS
+// thread, and releasing the packet after handler returns. Run returns nil once
+// End is called, or the error that stopped it from receiving. handler must not
+// call End itself, as End waits for Run to return.
+func (session Session) Run(handler func(packet []byte)) error {
+	if !session.state.acquire() {
+		return ErrSessionEnded
+	}
+	defer session.state.release()
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	thread := windows.CurrentThread()
+	priority := ThreadPriorityNormal
+	defer func() {
+		if priority != ThreadPriorityNormal {
+			setThreadPriority(thread, ThreadPriorityNormal)
+		}
+	}()
+	event := session.ReadWaitEvent()
+	for {
+		if want := ThreadPriority(atomic.LoadInt32(&session.state.priority)); want != priority {
+			setThreadPriority(thread, want)
+			priority = want
+		}
+		packet, ring, err := session.receiveTransformed()
+		switch err {
+		case nil:
+			if atomic.LoadInt32(&session.state.latency.enabled) != 0 {
+				start := time.Now()
+				handler(packet)
+				session.state.latency.record(time.Since(start))
+			} else {
+				handler(packet)
+			}
+			session.ReleaseReceivePacket(ring)
+		case windows.ERROR_NO_MORE_ITEMS:
+			if atomic.LoadInt32(&session.state.ending) != 0 {
+				return nil
+			}
+			windows.WaitForSingleObject(event, windows.INFINITE)
+		case ErrSessionEnded:
+			return nil
+		default:
+			return err
+		}
+	}
+}
@@ -8,7 +8,12 @@
 package wintun
 
 import (
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
 	"syscall"
+	"time"
 	"unsafe"
 
 	"golang.org/x/sys/windows"
@@ -16,8 +21,83 @@ import (
 
 type Session struct {
 	handle uintptr
+	state  *sessionState
 }
 
+// sessionState is the Go-side bookkeeping shared by all copies of a Session.
+type sessionState struct {
+	adapter     *Adapter
+	lastReceive int64 // Unix nanoseconds of the last received packet, accessed atomically
+	counters    sessionCounters
+	flows       flowTable
+	overflows   chan OverflowEvent
+	active      int32 // Number of packet operations under way, accessed atomically
+	ending      int32 // Set to 1 once End has been called, accessed atomically
+	idle        chan struct{}
+	ended       chan struct{}
+	priority    int32          // ThreadPriority for Run, accessed atomically
+	capture     unsafe.Pointer // *rotatingCapture, accessed atomically
+
+	transformsMu      sync.RWMutex
+	sendTransforms    []PacketTransform
+	receiveTransforms []PacketTransform
+
+	errMu   sync.Mutex
+	lastErr error
+
+	latency          latencyHistogram
+	autoFixChecksums int32 // accessed atomically
+	copyMode         int32 // CopyMode, accessed atomically
+	filter           protocolFilter
+
+	pooledMu sync.Mutex
+	pooled   map[uintptr]*[PacketSizeMax]byte // Pool buffers held by the caller, by address
+}
+
+// acquire registers the start of a packet operation, unless the session is
+// ending.
+func (state *sessionState) acquire() bool {
+	atomic.AddInt32(&state.active, 1)
+	if atomic.LoadInt32(&state.ending) != 0 {
+		state.release()
+		return false
+	}
+	return true
+}
+
+// release registers the end of a packet operation started with acquire.
+func (state *sessionState) release() {
+	if atomic.AddInt32(&state.active, -1) == 0 && atomic.LoadInt32(&state.ending) != 0 {
+		select {
+		case state.idle <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// ErrSessionEnded is returned by packet operations on a session that has
+// ended, including ones that were under way on another goroutine when End was
+// called.
+var ErrSessionEnded = errors.New("Wintun session has ended")
+
+// OverflowDirection is the direction of the ring that overflowed.
+type OverflowDirection int
+
+const (
+	OverflowSend    OverflowDirection = iota // Send ring full
+	OverflowReceive                          // Packet dropped from receive ring
+)
+
+// OverflowEvent reports a ring buffer overflow.
+type OverflowEvent struct {
+	Direction OverflowDirection
+	Time      time.Time
+}
+
+// overflowEventsMax is the number of overflow events buffered before new ones
+// are dropped.
+const overflowEventsMax = 64
+
 const (
 	PacketSizeMax   = 0xffff    // Maximum packet size
 	RingCapacityMin = 0x20000   // Minimum ring capacity (128 kiB)
@@ -42,45 +122,300 @@ var (
 )
 
 func (wintun *Adapter) StartSession(capacity uint32) (session Session, err error) {
-	r0, _, e1 := syscall.Syscall(procWintunStartSession.Addr(), 2, uintptr(wintun.handle), uintptr(capacity), 0)
+	r0, _, e1 := callProc(procWintunStartSession, nil, uintptr(wintun.handle), uintptr(capacity))
 	if r0 == 0 {
 		err = e1
 	} else {
-		session = Session{r0}
+		session = Session{r0, &sessionState{
+			adapter:     wintun,
+			lastReceive: time.Now().UnixNano(),
+			overflows:   make(chan OverflowEvent, overflowEventsMax),
+			idle:        make(chan struct{}, 1),
+			ended:       make(chan struct{}),
+		}}
+		liveSessions.add(session)
 	}
 	return
 }
 
+// CreateSession creates an adapter from config and starts a session on it with
+// the given ring capacity. If the session cannot be started, the adapter is
+// closed again before returning the error.
+func CreateSession(config AdapterConfig, capacity uint32) (*Adapter, *Session, error) {
+	wintun, err := CreateAdapterWithConfig(config)
+	if err != nil {
+		return nil, nil, err
+	}
+	session, err := wintun.StartSession(capacity)
+	if err != nil {
+		wintun.Close()
+		return nil, nil, err
+	}
+	return wintun, &session, nil
+}
+
+// End ends the session. It wakes up Run and waits for packet operations under
+// way on other goroutines to return, signaling the read wait event until they
+// all have; packet operations started afterwards return ErrSessionEnded.
+// Packets received but not yet released become invalid.
 func (session Session) End() {
-	syscall.Syscall(procWintunEndSession.Addr(), 1, session.handle, 0, 0)
+	state := session.state
+	if !atomic.CompareAndSwapInt32(&state.ending, 0, 1) {
+		<-state.ended
+		return
+	}
+	// The read event is auto-reset, so each signal wakes a single waiter;
+	// keep signaling until every packet operation has returned.
+	event := session.ReadWaitEvent()
+	windows.SetEvent(event)
+	for atomic.LoadInt32(&state.active) != 0 {
+		windows.SetEvent(event)
+		select {
+		case <-state.idle:
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	callProc(procWintunEndSession, nil, session.handle)
 	session.handle = 0
+	liveSessions.remove(state)
+	close(state.ended)
+}
+
+// Restart ends the session and starts a new one on the same adapter with the
+// given ring capacity, leaving the adapter, and so its addresses and routes, in
+// place. It can be used to change the ring capacity or to recover from a
+// corrupt ring. The old session must not be used afterwards. The new session
+// has its own read wait event and starts with zeroed statistics, but carries
+// over every setting of the old one: the flow statistics limit, the Run thread
+// priority, the transforms, automatic checksum fixing, handler latency
+// measurement, the receive copy mode and the receive filter. A capture started
+// with DumpToRotating keeps writing the old session, so it stops receiving
+// packets. If the new session cannot be started, Restart returns the old,
+// ended, session with the error; its packet operations return ErrSessionEnded.
+func (session Session) Restart(capacity uint32) (Session, error) {
+	old := session.state
+	session.End()
+	restarted, err := old.adapter.StartSession(capacity)
+	if err != nil {
+		return session, err
+	}
+	restarted.state.copySettings(old)
+	return restarted, nil
 }
 
+// copySettings copies the settings of from, as opposed to its statistics and
+// packets, to state.
+func (state *sessionState) copySettings(from *sessionState) {
+	state.flows.setMax(int(atomic.LoadInt32(&from.flows.max)))
+	atomic.StoreInt32(&state.priority, atomic.LoadInt32(&from.priority))
+	from.transformsMu.RLock()
+	state.sendTransforms = from.sendTransforms
+	state.receiveTransforms = from.receiveTransforms
+	from.transformsMu.RUnlock()
+	atomic.StoreInt32(&state.autoFixChecksums, atomic.LoadInt32(&from.autoFixChecksums))
+	atomic.StoreInt32(&state.latency.enabled, atomic.LoadInt32(&from.latency.enabled))
+	atomic.StoreInt32(&state.copyMode, atomic.LoadInt32(&from.copyMode))
+	atomic.StorePointer(&state.filter.allowed, atomic.LoadPointer(&from.filter.allowed))
+}
+
+// ReadWaitEvent returns the event signaled when packets arrive in the receive
+// ring. It is an auto-reset event, so each signal wakes a single waiter. End
+// signals it until the packet operations under way return, but a goroutine
+// waiting on it outside a packet operation may miss the signal when several
+// wait, and so should wait with a timeout and then check for ErrSessionEnded.
 func (session Session) ReadWaitEvent() (handle windows.Handle) {
-	r0, _, _ := syscall.Syscall(procWintunGetReadWaitEvent.Addr(), 1, session.handle, 0, 0)
+	r0, _, _ := callProc(procWintunGetReadWaitEvent, nil, session.handle)
 	handle = windows.Handle(r0)
 	return
 }
 
 func (session Session) ReceivePacket() (packet []byte, err error) {
-	var packetSize uint32
-	r0, _, e1 := syscall.Syscall(procWintunReceivePacket.Addr(), 2, session.handle, uintptr(unsafe.Pointer(&packetSize)), 0)
-	if r0 == 0 {
-		err = e1
+	if !session.state.acquire() {
+		err = ErrSessionEnded
 		return
 	}
-	packet = unsafe.Slice((*byte)(unsafe.Pointer(r0)), packetSize)
+	defer session.state.release()
+	var packetSize uint32
+	var r0 uintptr
+	var e1 syscall.Errno
+	for {
+		if atomic.LoadInt32(&serializedCalls) == 0 {
+			// Fast path: packetSize stays on the stack, as only the
+			// serialized call below needs a heap copy of it.
+			r0, _, e1 = syscall.Syscall(procWintunReceivePacket.Addr(), 2, session.handle, uintptr(unsafe.Pointer(&packetSize)), 0)
+		} else {
+			size := new(uint32)
+			r0, _, e1 = callProc(procWintunReceivePacket, []unsafe.Pointer{unsafe.Pointer(size)}, session.handle, uintptr(unsafe.Pointer(size)))
+			packetSize = *size
+		}
+		if r0 == 0 {
+			switch e1 {
+			case windows.ERROR_NO_MORE_ITEMS:
+				err = e1
+			case windows.ERROR_HANDLE_EOF:
+				err = ErrSessionEnded
+				session.state.setError(err)
+			default:
+				err = e1
+				session.state.setError(err)
+			}
+			return
+		}
+		packet = unsafe.Slice((*byte)(unsafe.Pointer(r0)), packetSize)
+		if !session.state.filter.allows(packet) {
+			callProc(procWintunReleaseReceivePacket, nil, session.handle, r0)
+			atomic.AddUint64(&session.state.counters.receiveDropped, 1)
+			continue
+		}
+		break
+	}
+	if CopyMode(atomic.LoadInt32(&session.state.copyMode)) == PooledCopy {
+		buf := receivePool.Get().(*[PacketSizeMax]byte)
+		ring := packet
+		packet = buf[:copy(buf[:], ring)]
+		callProc(procWintunReleaseReceivePacket, nil, session.handle, uintptr(unsafe.Pointer(&ring[0])))
+		session.state.holdPooled(buf)
+	}
+	atomic.StoreInt64(&session.state.lastReceive, time.Now().UnixNano())
+	atomic.AddUint64(&session.state.counters.receivedPackets, 1)
+	atomic.AddUint64(&session.state.counters.receivedBytes, uint64(packetSize))
+	session.state.flows.record(packet)
+	session.state.captured(packet)
 	return
 }
 
+// ReceiveBatchInto receives up to len(bufs) packets, copying each into the
+// buffer of the corresponding element of bufs, up to its capacity, and
+// releasing it right away. Each filled element is resliced to the length of its
+// packet, and the number of filled elements is returned. It only returns
+// ERROR_NO_MORE_ITEMS when no packet at all was available. Receive transforms
+// are applied before copying. A packet larger than the capacity of its buffer
+// is dropped, and io.ErrShortBuffer is returned along with the number of
+// packets filled before it.
+func (session Session) ReceiveBatchInto(bufs [][]byte) (n int, err error) {
+	for n < len(bufs) {
+		packet, ring, err := session.receiveTransformed()
+		if err != nil {
+			if err == windows.ERROR_NO_MORE_ITEMS && n > 0 {
+				err = nil
+			}
+			return n, err
+		}
+		if len(packet) > cap(bufs[n]) {
+			session.ReleaseReceivePacket(ring)
+			return n, io.ErrShortBuffer
+		}
+		bufs[n] = bufs[n][:len(packet)]
+		copy(bufs[n], packet)
+		session.ReleaseReceivePacket(ring)
+		n++
+	}
+	return n, nil
+}
+
+// ReleaseReceivePacket releases a packet returned by ReceivePacket. For a packet
+// received in the PooledCopy mode, it returns the packet's buffer to the pool
+// instead, whatever the current mode, and the packet may have been resliced.
+// A packet received in the AliasDriverBuffer mode must be given as returned.
 func (session Session) ReleaseReceivePacket(packet []byte) {
-	syscall.Syscall(procWintunReleaseReceivePacket.Addr(), 2, session.handle, uintptr(unsafe.Pointer(&packet[0])), 0)
+	if buf := session.state.takePooled(packet); buf != nil {
+		receivePool.Put(buf)
+		return
+	}
+	if !session.state.acquire() {
+		return
+	}
+	defer session.state.release()
+	callProc(procWintunReleaseReceivePacket, nil, session.handle, uintptr(unsafe.Pointer(&packet[0])))
+}
+
+// CopyMode selects who owns the packets that ReceivePacket returns.
+type CopyMode int32
+
+const (
+	// AliasDriverBuffer returns packets pointing into the receive ring, which
+	// costs no copy, but each occupies ring space until released, and the
+	// driver drops incoming packets once the ring fills up. Packets must be
+	// released promptly, and become invalid when the session ends.
+	AliasDriverBuffer CopyMode = iota
+
+	// PooledCopy copies each packet into a buffer from a pool and releases
+	// its ring space right away, so that packets can be held for as long as
+	// needed, even past the end of the session, at the cost of a copy and a
+	// 64 KiB buffer per packet held. ReleaseReceivePacket returns the buffer
+	// to the pool, after which it must no longer be used.
+	PooledCopy
+)
+
+var receivePool = sync.Pool{New: func() interface{} { return new([PacketSizeMax]byte) }}
+
+// SetReceiveCopyMode sets the ownership of the packets that ReceivePacket
+// returns, AliasDriverBuffer by default. It only affects packets received
+// afterwards; packets already received are released according to the mode
+// they were received with.
+func (session Session) SetReceiveCopyMode(mode CopyMode) {
+	atomic.StoreInt32(&session.state.copyMode, int32(mode))
+}
+
+// holdPooled records buf as handed out to the caller.
+func (state *sessionState) holdPooled(buf *[PacketSizeMax]byte) {
+	state.pooledMu.Lock()
+	defer state.pooledMu.Unlock()
+	if state.pooled == nil {
+		state.pooled = make(map[uintptr]*[PacketSizeMax]byte)
+	}
+	state.pooled[uintptr(unsafe.Pointer(buf))] = buf
+}
+
+// takePooled returns the pool buffer that packet lies in, forgetting it, or nil
+// if packet does not lie in a pool buffer handed out by the session.
+func (state *sessionState) takePooled(packet []byte) *[PacketSizeMax]byte {
+	if len(packet) == 0 {
+		return nil
+	}
+	state.pooledMu.Lock()
+	defer state.pooledMu.Unlock()
+	if len(state.pooled) == 0 {
+		return nil
+	}
+	addr := uintptr(unsafe.Pointer(&packet[0]))
+	buf := state.pooled[addr]
+	if buf == nil {
+		// The packet may have been resliced past its start.
+		for base, b := range state.pooled {
+			if addr >= base && addr < base+PacketSizeMax {
+				buf = b
+				break
+			}
+		}
+		if buf == nil {
+			return nil
+		}
+	}
+	delete(state.pooled, uintptr(unsafe.Pointer(buf)))
+	return buf
 }
 
 func (session Session) AllocateSendPacket(packetSize int) (packet []byte, err error) {
-	r0, _, e1 := syscall.Syscall(procWintunAllocateSendPacket.Addr(), 2, session.handle, uintptr(packetSize), 0)
+	if !session.state.acquire() {
+		err = ErrSessionEnded
+		return
+	}
+	defer session.state.release()
+	r0, _, e1 := callProc(procWintunAllocateSendPacket, nil, session.handle, uintptr(packetSize))
 	if r0 == 0 {
-		err = e1
+		switch e1 {
+		case windows.ERROR_BUFFER_OVERFLOW:
+			atomic.AddUint64(&session.state.counters.sendOverflows, 1)
+			session.notifyOverflow(OverflowSend)
+			err = e1
+		case windows.ERROR_HANDLE_EOF:
+			err = ErrSessionEnded
+			session.state.setError(err)
+		default:
+			err = e1
+			session.state.setError(err)
+		}
 		return
 	}
 	packet = unsafe.Slice((*byte)(unsafe.Pointer(r0)), packetSize)
@@ -88,5 +423,59 @@ func (session Session) AllocateSendPacket(packetSize int) (packet []byte, err er
 }
 
 func (session Session) SendPacket(packet []byte) {
-	syscall.Syscall(procWintunSendPacket.Addr(), 2, session.handle, uintptr(unsafe.Pointer(&packet[0])), 0)
+	if !session.state.acquire() {
+		return
+	}
+	defer session.state.release()
+	if atomic.LoadInt32(&session.state.autoFixChecksums) != 0 {
+		FixChecksums(packet)
+	}
+	// The packet belongs to the driver once sent, so account for it before.
+	atomic.AddUint64(&session.state.counters.sentPackets, 1)
+	atomic.AddUint64(&session.state.counters.sentBytes, uint64(len(packet)))
+	session.state.flows.record(packet)
+	session.state.captured(packet)
+	callProc(procWintunSendPacket, nil, session.handle, uintptr(unsafe.Pointer(&packet[0])))
+}
+
+// setError records a fatal error of the session for LastError.
+func (state *sessionState) setError(err error) {
+	state.errMu.Lock()
+	state.lastErr = err
+	state.errMu.Unlock()
+}
+
+// LastError returns the last fatal error that a packet operation on the
+// session ran into, such as ERROR_INVALID_DATA for a corrupt ring, or
+// ErrSessionEnded once the adapter is removed, or nil if there was none since
+// the session started or ClearError was called. Transient conditions, an empty
+// receive ring or a full send ring, are not recorded. Such errors usually
+// persist until the session is restarted with Restart.
+func (session Session) LastError() error {
+	session.state.errMu.Lock()
+	defer session.state.errMu.Unlock()
+	return session.state.lastErr
+}
+
+// ClearError clears the error returned by LastError, typically before retrying
+// after a supervisor decided the error was recoverable.
+func (session Session) ClearError() {
+	session.state.setError(nil)
+}
+
+// OverflowEvents returns a channel that receives an event each time a send
+// allocation fails because the ring is full. The driver does not report
+// packets it drops from the receive ring, so OverflowReceive events are never
+// delivered by this version. The channel buffers a limited number of events;
+// events that occur while it is full are discarded, so a slow consumer misses
+// events rather than slowing down the data path.
+func (session Session) OverflowEvents() <-chan OverflowEvent {
+	return session.state.overflows
+}
+
+func (session Session) notifyOverflow(direction OverflowDirection) {
+	select {
+	case session.state.overflows <- OverflowEvent{direction, time.Now()}:
+	default:
+	}
 }
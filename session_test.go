@@ -0,0 +1,185 @@
+//go:build windows
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package wintun
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/windows"
+)
+
+func TestEndWhileReceiving(t *testing.T) {
+	wintun := createTestAdapter(t, testAdapterName, nil)
+	for i := 0; i < 20; i++ {
+		session, err := wintun.StartSession(RingCapacityMin)
+		if err != nil {
+			t.Fatalf("StartSession failed: %v", err)
+		}
+		waiting := make(chan struct{})
+		done := make(chan error, 1)
+		go func() {
+			event := session.ReadWaitEvent()
+			close(waiting)
+			for {
+				packet, err := session.ReceivePacket()
+				switch err {
+				case nil:
+					session.ReleaseReceivePacket(packet)
+				case windows.ERROR_NO_MORE_ITEMS:
+					windows.WaitForSingleObject(event, windows.INFINITE)
+				default:
+					done <- err
+					return
+				}
+			}
+		}()
+		<-waiting
+		time.Sleep(time.Duration(i) * time.Millisecond)
+		session.End()
+		select {
+		case err := <-done:
+			if err != ErrSessionEnded {
+				t.Fatalf("ReceivePacket returned %v after End, want ErrSessionEnded", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("ReceivePacket loop still running 5s after End")
+		}
+		if _, err := session.ReceivePacket(); err != ErrSessionEnded {
+			t.Fatalf("ReceivePacket on an ended session returned %v, want ErrSessionEnded", err)
+		}
+		if _, err := session.AllocateSendPacket(20); err != ErrSessionEnded {
+			t.Fatalf("AllocateSendPacket on an ended session returned %v, want ErrSessionEnded", err)
+		}
+	}
+}
+
+func TestEndWhileSeveralWait(t *testing.T) {
+	wintun := createTestAdapter(t, testAdapterName, nil)
+	session, err := wintun.StartSession(RingCapacityMin)
+	if err != nil {
+		t.Fatalf("StartSession failed: %v", err)
+	}
+	// Filter everything out, so that the waiters only wake up for End.
+	session.SetReceiveFilter([]uint8{})
+	const runners, loops = 2, 2
+	done := make(chan error, runners+loops)
+	for i := 0; i < runners; i++ {
+		go func() {
+			done <- session.Run(func(packet []byte) {})
+		}()
+	}
+	for i := 0; i < loops; i++ {
+		go func() {
+			event := session.ReadWaitEvent()
+			for {
+				packet, err := session.ReceivePacket()
+				switch err {
+				case nil:
+					session.ReleaseReceivePacket(packet)
+				case windows.ERROR_NO_MORE_ITEMS:
+					windows.WaitForSingleObject(event, 10)
+				case ErrSessionEnded:
+					done <- nil
+					return
+				default:
+					done <- err
+					return
+				}
+			}
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	ended := make(chan struct{})
+	go func() {
+		session.End()
+		close(ended)
+	}()
+	select {
+	case <-ended:
+	case <-time.After(5 * time.Second):
+		t.Fatal("End still waiting 5s after being called")
+	}
+	for i := 0; i < runners+loops; i++ {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Errorf("Waiter returned %v after End, want nil", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Waiter still running 5s after End")
+		}
+	}
+}
+
+func TestEndWhileRunning(t *testing.T) {
+	wintun := createTestAdapter(t, testAdapterName, nil)
+	session, err := wintun.StartSession(RingCapacityMin)
+	if err != nil {
+		t.Fatalf("StartSession failed: %v", err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- session.Run(func(packet []byte) {})
+	}()
+	time.Sleep(10 * time.Millisecond)
+	session.End()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Run returned %v after End, want nil", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run still running 5s after End")
+	}
+}
+
+func BenchmarkReceiveBatchInto(b *testing.B) {
+	wintun := createTestAdapter(b, testAdapterName, nil)
+	session, err := wintun.StartSession(RingCapacityMax)
+	if err != nil {
+		b.Fatalf("StartSession failed: %v", err)
+	}
+	defer session.End()
+	if err := wintun.SetIPAddress(netip.MustParsePrefix("198.18.0.1/24")); err != nil {
+		b.Fatalf("SetIPAddress failed: %v", err)
+	}
+	// Datagrams to another address of the subnet are routed to the adapter,
+	// and so land in the receive ring.
+	conn, err := net.DialUDP("udp4", nil, &net.UDPAddr{IP: net.IPv4(198, 18, 0, 2), Port: 9})
+	if err != nil {
+		b.Fatalf("DialUDP failed: %v", err)
+	}
+	defer conn.Close()
+	payload := make([]byte, 1200)
+	bufs := make([][]byte, 16)
+	for i := range bufs {
+		bufs[i] = make([]byte, 0, 2048)
+	}
+	event := session.ReadWaitEvent()
+	b.ReportAllocs()
+	b.ResetTimer()
+	var packets int
+	for i := 0; i < b.N; i++ {
+		// Only count the receive path, not the sending of datagrams.
+		b.StopTimer()
+		for range bufs {
+			conn.Write(payload)
+		}
+		windows.WaitForSingleObject(event, 1000)
+		b.StartTimer()
+		n, err := session.ReceiveBatchInto(bufs)
+		if err != nil && err != windows.ERROR_NO_MORE_ITEMS {
+			b.Fatalf("ReceiveBatchInto failed: %v", err)
+		}
+		packets += n
+	}
+	b.ReportMetric(float64(packets)/float64(b.N), "packets/op")
+}
@@ -0,0 +1,153 @@
+//go:build windows
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package wintun
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/registry"
+)
+
+var (
+	modsetupapi                      = windows.NewLazySystemDLL("setupapi.dll")
+	procSetupDiDestroyDeviceInfoList = modsetupapi.NewProc("SetupDiDestroyDeviceInfoList")
+	procSetupDiEnumDeviceInfo        = modsetupapi.NewProc("SetupDiEnumDeviceInfo")
+	procSetupDiGetClassDevsW         = modsetupapi.NewProc("SetupDiGetClassDevsW")
+	procSetupDiGetDeviceInstanceIdW  = modsetupapi.NewProc("SetupDiGetDeviceInstanceIdW")
+	procSetupDiOpenDevRegKey         = modsetupapi.NewProc("SetupDiOpenDevRegKey")
+)
+
+// guidDevClassNet is GUID_DEVCLASS_NET, the network adapter device class.
+var guidDevClassNet = windows.GUID{Data1: 0x4d36e974, Data2: 0xe325, Data3: 0x11ce, Data4: [8]byte{0xbf, 0xc1, 0x08, 0x00, 0x2b, 0xe1, 0x03, 0x18}}
+
+// spDevinfoData is the SP_DEVINFO_DATA structure.
+type spDevinfoData struct {
+	size      uint32
+	ClassGUID windows.GUID
+	DevInst   uint32
+	_         uintptr
+}
+
+// netDeviceInstanceID returns the device instance ID of the present network
+// adapter whose driver key has the NetCfgInstanceId guid.
+func netDeviceInstanceID(guid windows.GUID) (string, error) {
+	const (
+		DIGCF_PRESENT     = 0x2
+		DICS_FLAG_GLOBAL  = 0x1
+		DIREG_DRV         = 0x2
+		MAX_DEVICE_ID_LEN = 200
+	)
+	r0, _, e1 := syscall.Syscall6(procSetupDiGetClassDevsW.Addr(), 4, uintptr(unsafe.Pointer(&guidDevClassNet)), 0, 0, DIGCF_PRESENT, 0, 0)
+	devInfo := windows.Handle(r0)
+	if devInfo == windows.InvalidHandle {
+		return "", e1
+	}
+	defer syscall.Syscall(procSetupDiDestroyDeviceInfoList.Addr(), 1, uintptr(devInfo), 0, 0)
+	for index := uint32(0); ; index++ {
+		data := spDevinfoData{size: uint32(unsafe.Sizeof(spDevinfoData{}))}
+		r1, _, e1 := syscall.Syscall(procSetupDiEnumDeviceInfo.Addr(), 3, uintptr(devInfo), uintptr(index), uintptr(unsafe.Pointer(&data)))
+		if r1 == 0 {
+			if e1 == windows.ERROR_NO_MORE_ITEMS {
+				return "", windows.ERROR_NOT_FOUND
+			}
+			return "", e1
+		}
+		r0, _, _ := syscall.Syscall6(procSetupDiOpenDevRegKey.Addr(), 6, uintptr(devInfo), uintptr(unsafe.Pointer(&data)), DICS_FLAG_GLOBAL, 0, DIREG_DRV, registry.QUERY_VALUE)
+		if windows.Handle(r0) == windows.InvalidHandle {
+			continue
+		}
+		key := registry.Key(r0)
+		instanceID, _, err := key.GetStringValue("NetCfgInstanceId")
+		key.Close()
+		if err != nil || !strings.EqualFold(instanceID, guid.String()) {
+			continue
+		}
+		var buf [MAX_DEVICE_ID_LEN]uint16
+		r1, _, e1 = syscall.Syscall6(procSetupDiGetDeviceInstanceIdW.Addr(), 5, uintptr(devInfo), uintptr(unsafe.Pointer(&data)), uintptr(unsafe.Pointer(&buf[0])), uintptr(len(buf)), 0, 0)
+		if r1 == 0 {
+			return "", e1
+		}
+		return windows.UTF16ToString(buf[:]), nil
+	}
+}
+
+// SetupLogEntry is a section of the SetupAPI device installation log.
+type SetupLogEntry struct {
+	Start      time.Time // Local time the section started
+	Header     string    // Section title, such as "Device Install (Hardware initiated) - SWD\WINTUN\{...}"
+	Lines      []string  // Lines between the section start and end, trimmed
+	ExitStatus string    // Exit status reported at the end of the section, such as "SUCCESS"
+}
+
+// SetupLogEntries returns the sections of %windir%\inf\setupapi.dev.log that
+// concern Wintun and started at or after since. When creating or removing an
+// adapter fails, these sections usually contain the reason.
+func SetupLogEntries(since time.Time) ([]SetupLogEntry, error) {
+	windir, err := windows.GetSystemWindowsDirectory()
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(filepath.Join(windir, "inf", "setupapi.dev.log"))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	const (
+		headerPrefix = ">>>  ["
+		startPrefix  = ">>>  Section start "
+		endPrefix    = "<<<  Section end "
+		statusPrefix = "<<<  [Exit status: "
+		timeLayout   = "2006/01/02 15:04:05.000"
+	)
+	var entries []SetupLogEntry
+	var entry *SetupLogEntry
+	relevant := false
+	finish := func() {
+		if entry != nil && relevant && !entry.Start.Before(since) {
+			entries = append(entries, *entry)
+		}
+		entry = nil
+		relevant = false
+	}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, headerPrefix):
+			finish()
+			entry = &SetupLogEntry{Header: strings.TrimSuffix(strings.TrimPrefix(line, headerPrefix), "]")}
+			relevant = containsWintun(entry.Header)
+		case entry == nil:
+		case strings.HasPrefix(line, startPrefix):
+			entry.Start, _ = time.ParseInLocation(timeLayout, strings.TrimPrefix(line, startPrefix), time.Local)
+		case strings.HasPrefix(line, endPrefix):
+		case strings.HasPrefix(line, statusPrefix):
+			entry.ExitStatus = strings.TrimSuffix(strings.TrimPrefix(line, statusPrefix), "]")
+			finish()
+		default:
+			line = strings.TrimSpace(line)
+			entry.Lines = append(entry.Lines, line)
+			relevant = relevant || containsWintun(line)
+		}
+	}
+	finish()
+	return entries, scanner.Err()
+}
+
+func containsWintun(s string) bool {
+	return strings.Contains(strings.ToLower(s), "wintun")
+}
@@ -0,0 +1,107 @@
+//go:build windows
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package wintun
+
+import (
+	"sync"
+	"syscall"
+
+	"golang.org/x/sys/windows"
+)
+
+var procSetConsoleCtrlHandler = modkernel32.NewProc("SetConsoleCtrlHandler")
+
+// adapterRegistry tracks the handles of the adapters created by CreateAdapter
+// and not closed yet. It holds raw handles rather than adapters, so that an
+// adapter dropped without Close still becomes unreachable and is closed by its
+// finalizer. Whoever takes a handle out of the registry is the one to close it.
+type adapterRegistry struct {
+	mu      sync.Mutex
+	handles map[uintptr]struct{}
+}
+
+var liveAdapters adapterRegistry
+
+func (r *adapterRegistry) add(handle uintptr) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.handles == nil {
+		r.handles = make(map[uintptr]struct{})
+	}
+	r.handles[handle] = struct{}{}
+}
+
+// take removes handle from the registry, and reports whether it was there.
+func (r *adapterRegistry) take(handle uintptr) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.handles[handle]; !ok {
+		return false
+	}
+	delete(r.handles, handle)
+	return true
+}
+
+// takeAll empties the registry and returns the handles it held.
+func (r *adapterRegistry) takeAll() []uintptr {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	handles := make([]uintptr, 0, len(r.handles))
+	for handle := range r.handles {
+		handles = append(handles, handle)
+	}
+	r.handles = nil
+	return handles
+}
+
+var (
+	shutdownHookOnce sync.Once
+	shutdownHookErr  error
+	shutdownOnce     sync.Once
+)
+
+// RegisterShutdownHook installs a console control handler that, when the
+// process is interrupted with Ctrl+C or Ctrl+Break, or its console is closed,
+// or the user logs off, or the system shuts down, ends every running session
+// and removes every adapter created by CreateAdapter and not closed yet,
+// before letting the process terminate. Removing an adapter removes the
+// addresses and routes configured on it, and the hook also clears the DNS
+// settings written by SetDNS, which are kept by GUID and would otherwise come
+// back on the next adapter created with the same GUID. Windows terminates a
+// process after these events, by default right away for Ctrl+C, without
+// running finalizers or deferred calls, so this is the only way to not leave
+// adapters behind. The handlers installed before, such as the one that
+// delivers os.Interrupt to os/signal, still run afterwards, but a process that
+// keeps running after Ctrl+C will have lost its adapters. Services, which
+// receive logoff events for every user, should not use it. Calling it more
+// than once has no further effect.
+func RegisterShutdownHook() error {
+	shutdownHookOnce.Do(func() {
+		r1, _, e1 := syscall.Syscall(procSetConsoleCtrlHandler.Addr(), 2, windows.NewCallback(shutdownHandler), 1, 0)
+		if r1 == 0 {
+			shutdownHookErr = e1
+		}
+	})
+	return shutdownHookErr
+}
+
+func shutdownHandler(ctrlType uint32) uintptr {
+	shutdownOnce.Do(func() {
+		for _, session := range liveSessions.snapshot() {
+			session.End()
+		}
+		for _, handle := range liveAdapters.takeAll() {
+			// A temporary adapter, without a finalizer, to reach the
+			// adapter's settings.
+			(&Adapter{handle: handle}).SetDNS(nil, nil)
+			callProc(procWintunCloseAdapter, nil, handle)
+		}
+	})
+	// Let the next handler decide what to do with the event.
+	return 0
+}
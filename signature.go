@@ -0,0 +1,119 @@
+//go:build windows
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package wintun
+
+import (
+	"fmt"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	modcrypt32           = windows.NewLazySystemDLL("crypt32.dll")
+	procCryptMsgClose    = modcrypt32.NewProc("CryptMsgClose")
+	procCryptMsgGetParam = modcrypt32.NewProc("CryptMsgGetParam")
+)
+
+// VerifyDLLSignature checks the Authenticode signature of wintun.dll, either
+// the loaded file or the one that would be loaded. If expectedSubject is not
+// empty, the signing certificate's subject name must also equal it, for
+// example "WireGuard LLC".
+func VerifyDLLSignature(expectedSubject string) error {
+	path, err := modwintun.path()
+	if err != nil {
+		return err
+	}
+	return verifySignature(path, expectedSubject)
+}
+
+// RequireDLLSignature makes the lazy loader call VerifyDLLSignature with
+// expectedSubject before loading wintun.dll, and refuse to load it if the check
+// fails. The file is kept from being modified or replaced from the check until
+// it is loaded. It has no effect on a DLL that has already been loaded, but
+// Preflight then checks the loaded file.
+func RequireDLLSignature(expectedSubject string) {
+	modwintun.mu.Lock()
+	defer modwintun.mu.Unlock()
+	modwintun.verify = func(path string) error {
+		return verifySignature(path, expectedSubject)
+	}
+}
+
+func verifySignature(path string, expectedSubject string) error {
+	path16, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return err
+	}
+	fileInfo := &windows.WinTrustFileInfo{
+		Size:     uint32(unsafe.Sizeof(windows.WinTrustFileInfo{})),
+		FilePath: path16,
+	}
+	data := &windows.WinTrustData{
+		Size:                            uint32(unsafe.Sizeof(windows.WinTrustData{})),
+		UIChoice:                        windows.WTD_UI_NONE,
+		RevocationChecks:                windows.WTD_REVOKE_NONE,
+		UnionChoice:                     windows.WTD_CHOICE_FILE,
+		StateAction:                     windows.WTD_STATEACTION_VERIFY,
+		FileOrCatalogOrBlobOrSgnrOrCert: unsafe.Pointer(fileInfo),
+	}
+	err = windows.WinVerifyTrustEx(windows.InvalidHWND, &windows.WINTRUST_ACTION_GENERIC_VERIFY_V2, data)
+	data.StateAction = windows.WTD_STATEACTION_CLOSE
+	windows.WinVerifyTrustEx(windows.InvalidHWND, &windows.WINTRUST_ACTION_GENERIC_VERIFY_V2, data)
+	if err != nil {
+		return fmt.Errorf("Invalid signature on %v: %w", path, err)
+	}
+	if expectedSubject == "" {
+		return nil
+	}
+	subject, err := signerSubject(path16)
+	if err != nil {
+		return fmt.Errorf("Unable to read signer of %v: %w", path, err)
+	}
+	if subject != expectedSubject {
+		return fmt.Errorf("%v is signed by %q rather than %q", path, subject, expectedSubject)
+	}
+	return nil
+}
+
+// signerSubject returns the simple display name of the certificate that signed
+// the file at path.
+func signerSubject(path *uint16) (string, error) {
+	const CMSG_SIGNER_CERT_INFO_PARAM = 7
+	var encoding, contentType, formatType uint32
+	var store, msg windows.Handle
+	err := windows.CryptQueryObject(windows.CERT_QUERY_OBJECT_FILE, unsafe.Pointer(path), windows.CERT_QUERY_CONTENT_FLAG_PKCS7_SIGNED_EMBED, windows.CERT_QUERY_FORMAT_FLAG_BINARY, 0, &encoding, &contentType, &formatType, &store, &msg, nil)
+	if err != nil {
+		return "", err
+	}
+	defer windows.CertCloseStore(store, 0)
+	defer syscall.Syscall(procCryptMsgClose.Addr(), 1, uintptr(msg), 0, 0)
+
+	var size uint32
+	r1, _, e1 := syscall.Syscall6(procCryptMsgGetParam.Addr(), 5, uintptr(msg), CMSG_SIGNER_CERT_INFO_PARAM, 0, 0, uintptr(unsafe.Pointer(&size)), 0)
+	if r1 == 0 {
+		return "", e1
+	}
+	buf := make([]byte, size)
+	r1, _, e1 = syscall.Syscall6(procCryptMsgGetParam.Addr(), 5, uintptr(msg), CMSG_SIGNER_CERT_INFO_PARAM, 0, uintptr(unsafe.Pointer(&buf[0])), uintptr(unsafe.Pointer(&size)), 0)
+	if r1 == 0 {
+		return "", e1
+	}
+	cert, err := windows.CertFindCertificateInStore(store, windows.X509_ASN_ENCODING|windows.PKCS_7_ASN_ENCODING, 0, windows.CERT_FIND_SUBJECT_CERT, unsafe.Pointer(&buf[0]), nil)
+	if err != nil {
+		return "", err
+	}
+	defer windows.CertFreeCertificateContext(cert)
+	var name [256]uint16
+	n := windows.CertGetNameString(cert, windows.CERT_NAME_SIMPLE_DISPLAY_TYPE, 0, nil, &name[0], uint32(len(name)))
+	if n == 0 {
+		return "", windows.ERROR_NOT_FOUND
+	}
+	return windows.UTF16ToString(name[:n]), nil
+}
@@ -0,0 +1,181 @@
+//go:build windows
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package wintun
+
+import (
+	"container/list"
+	"net/netip"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// sessionCounters are updated atomically by the packet operations.
+type sessionCounters struct {
+	receivedPackets uint64
+	receivedBytes   uint64
+	sentPackets     uint64
+	sentBytes       uint64
+	sendOverflows   uint64
+	receiveDropped  uint64
+	sendDropped     uint64
+}
+
+// Stats holds the counters of a session since it was started. Received
+// packets are those read from the adapter with ReceivePacket, and sent packets
+// are those injected into it with SendPacket.
+type Stats struct {
+	ReceivedPackets uint64
+	ReceivedBytes   uint64
+	SentPackets     uint64
+	SentBytes       uint64
+	SendOverflows   uint64 // Send allocations that failed because the ring was full
+	ReceiveDropped  uint64 // Received packets dropped by a receive transform or filter
+	SendDropped     uint64 // Packets passed to Send dropped by a send transform
+}
+
+// Stats returns the session's counters.
+func (session Session) Stats() Stats {
+	c := &session.state.counters
+	return Stats{
+		ReceivedPackets: atomic.LoadUint64(&c.receivedPackets),
+		ReceivedBytes:   atomic.LoadUint64(&c.receivedBytes),
+		SentPackets:     atomic.LoadUint64(&c.sentPackets),
+		SentBytes:       atomic.LoadUint64(&c.sentBytes),
+		SendOverflows:   atomic.LoadUint64(&c.sendOverflows),
+		ReceiveDropped:  atomic.LoadUint64(&c.receiveDropped),
+		SendDropped:     atomic.LoadUint64(&c.sendDropped),
+	}
+}
+
+// FlowStats holds the counters of the packets from one source address to one
+// destination address, in either direction through the session.
+type FlowStats struct {
+	Source      netip.Addr
+	Destination netip.Addr
+	Packets     uint64
+	Bytes       uint64
+	LastSeen    time.Time
+}
+
+// EnableFlowStats starts tracking per-flow counters for packets received and
+// sent through the session, keeping at most maxFlows flows. When a new flow
+// would exceed that, the least recently seen flow is evicted. A maxFlows of
+// zero disables tracking and discards the flows tracked so far.
+func (session Session) EnableFlowStats(maxFlows int) {
+	session.state.flows.setMax(maxFlows)
+}
+
+// Flows returns the tracked flows, busiest first by bytes.
+func (session Session) Flows() []FlowStats {
+	return session.state.flows.snapshot()
+}
+
+type flowKey struct {
+	source, destination netip.Addr
+}
+
+type flowTable struct {
+	max     int32 // accessed atomically, so the disabled case does not take mu
+	mu      sync.Mutex
+	entries map[flowKey]*list.Element
+	lru     list.List // of *FlowStats, most recently seen first
+}
+
+func (t *flowTable) setMax(max int) {
+	if max < 0 {
+		max = 0
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	atomic.StoreInt32(&t.max, int32(max))
+	if max == 0 {
+		t.entries = nil
+		t.lru.Init()
+		return
+	}
+	if t.entries == nil {
+		t.entries = make(map[flowKey]*list.Element)
+	}
+	for t.lru.Len() > max {
+		t.evictOldest()
+	}
+}
+
+func (t *flowTable) evictOldest() {
+	oldest := t.lru.Back()
+	flow := oldest.Value.(*FlowStats)
+	delete(t.entries, flowKey{flow.Source, flow.Destination})
+	t.lru.Remove(oldest)
+}
+
+func (t *flowTable) record(packet []byte) {
+	if atomic.LoadInt32(&t.max) == 0 {
+		return
+	}
+	source, destination, ok := packetAddrs(packet)
+	if !ok {
+		return
+	}
+	key := flowKey{source, destination}
+	now := time.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.entries == nil {
+		return
+	}
+	element, ok := t.entries[key]
+	if !ok {
+		if t.lru.Len() >= int(t.max) {
+			t.evictOldest()
+		}
+		element = t.lru.PushFront(&FlowStats{Source: source, Destination: destination})
+		t.entries[key] = element
+	} else {
+		t.lru.MoveToFront(element)
+	}
+	flow := element.Value.(*FlowStats)
+	flow.Packets++
+	flow.Bytes += uint64(len(packet))
+	flow.LastSeen = now
+}
+
+func (t *flowTable) snapshot() []FlowStats {
+	t.mu.Lock()
+	flows := make([]FlowStats, 0, t.lru.Len())
+	for element := t.lru.Front(); element != nil; element = element.Next() {
+		flows = append(flows, *element.Value.(*FlowStats))
+	}
+	t.mu.Unlock()
+	sort.Slice(flows, func(i, j int) bool {
+		return flows[i].Bytes > flows[j].Bytes
+	})
+	return flows
+}
+
+// packetAddrs returns the source and destination addresses of an IPv4 or IPv6
+// packet.
+func packetAddrs(packet []byte) (source, destination netip.Addr, ok bool) {
+	if len(packet) == 0 {
+		return
+	}
+	switch packet[0] >> 4 {
+	case 4:
+		if len(packet) < 20 {
+			return
+		}
+		return netip.AddrFrom4(*(*[4]byte)(packet[12:16])), netip.AddrFrom4(*(*[4]byte)(packet[16:20])), true
+	case 6:
+		if len(packet) < 40 {
+			return
+		}
+		return netip.AddrFrom16(*(*[16]byte)(packet[8:24])), netip.AddrFrom16(*(*[16]byte)(packet[24:40])), true
+	}
+	return
+}
@@ -0,0 +1,50 @@
+//go:build windows
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package wintun
+
+import (
+	"sync"
+	"time"
+)
+
+// Creating or closing an adapter makes Plug and Play install or remove a device
+// node, which it does asynchronously, and tearing down a device that is still
+// being set up, or setting up many at once, can make PnP operations time out
+// and fail, sometimes leaving half-installed devices behind. The throttle keeps
+// one such operation at a time within the process, and spaces them by at
+// least a minimum delay, so that PnP can settle in between.
+var (
+	pnpThrottleMu    sync.Mutex
+	pnpThrottleDelay = 100 * time.Millisecond
+	pnpThrottleLast  time.Time
+)
+
+// SetCreateThrottle sets the minimum delay between creating or closing an
+// adapter and the next creation or closing of one by this process. The default
+// is 100ms. Whatever the delay, these operations never run concurrently. Tests
+// and reconnect loops that churn through adapters would otherwise overwhelm
+// Plug and Play.
+func SetCreateThrottle(delay time.Duration) {
+	if delay < 0 {
+		delay = 0
+	}
+	pnpThrottleMu.Lock()
+	pnpThrottleDelay = delay
+	pnpThrottleMu.Unlock()
+}
+
+// throttlePnP runs fn once the throttle allows it.
+func throttlePnP(fn func()) {
+	pnpThrottleMu.Lock()
+	defer pnpThrottleMu.Unlock()
+	if wait := time.Until(pnpThrottleLast.Add(pnpThrottleDelay)); wait > 0 {
+		time.Sleep(wait)
+	}
+	fn()
+	pnpThrottleLast = time.Now()
+}
@@ -0,0 +1,164 @@
+//go:build windows
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package wintun
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+
+	"golang.org/x/sys/windows"
+)
+
+// PacketTransform rewrites a packet, returning the packet to use in its stead,
+// which may be pkt itself modified in place, or false to drop it.
+type PacketTransform func(pkt []byte) ([]byte, bool)
+
+// FixChecksumsTransform is a PacketTransform that recomputes the checksums of
+// the packet with FixChecksums, for use after transforms that rewrite
+// addresses or ports.
+func FixChecksumsTransform(pkt []byte) ([]byte, bool) {
+	FixChecksums(pkt)
+	return pkt, true
+}
+
+// SetAutoFixChecksums sets whether SendPacket, and so Send, recomputes the
+// checksums of every packet with FixChecksums right before handing it to the
+// driver, after any send transforms, so that packets rewritten without
+// updating their checksums are not delivered corrupt. It is off by default, as
+// it costs a pass over each packet.
+func (session Session) SetAutoFixChecksums(enabled bool) {
+	var value int32
+	if enabled {
+		value = 1
+	}
+	atomic.StoreInt32(&session.state.autoFixChecksums, value)
+}
+
+// AddSendTransform registers a transform applied to each outbound packet,
+// after the transforms registered before it, by Send, SendContext,
+// SendWithFamily and the connection returned by AsNetPacketConn. A packet
+// dropped by a transform is not passed to the following ones. SendPacket does
+// not apply transforms, since its packet is already allocated in the ring,
+// where it can neither change size nor be dropped.
+func (session Session) AddSendTransform(transform PacketTransform) {
+	state := session.state
+	state.transformsMu.Lock()
+	defer state.transformsMu.Unlock()
+	// Copy, so that applyTransforms can use the slice without the lock.
+	state.sendTransforms = append(state.sendTransforms[:len(state.sendTransforms):len(state.sendTransforms)], transform)
+}
+
+// AddReceiveTransform registers a transform applied to each inbound packet,
+// after the transforms registered before it, by Run, ReceiveBatchInto and the
+// connection returned by AsNetPacketConn. ReceivePacket does not apply
+// transforms, since its caller must release the packet as it is in the ring.
+// The ring packet is released whether a transform drops or rewrites it; a
+// transform that keeps it but returns a different buffer must not retain pkt.
+func (session Session) AddReceiveTransform(transform PacketTransform) {
+	state := session.state
+	state.transformsMu.Lock()
+	defer state.transformsMu.Unlock()
+	state.receiveTransforms = append(state.receiveTransforms[:len(state.receiveTransforms):len(state.receiveTransforms)], transform)
+}
+
+// receiveTransformed receives the next packet that the receive transforms do
+// not drop, releasing dropped ones. It returns the transformed packet, and the
+// ring packet to release once done with it.
+func (session Session) receiveTransformed() (packet, ring []byte, err error) {
+	state := session.state
+	state.transformsMu.RLock()
+	transforms := state.receiveTransforms
+	state.transformsMu.RUnlock()
+	for {
+		ring, err = session.ReceivePacket()
+		if err != nil {
+			return nil, nil, err
+		}
+		packet, ok := applyTransforms(transforms, ring)
+		if ok {
+			return packet, ring, nil
+		}
+		atomic.AddUint64(&state.counters.receiveDropped, 1)
+		session.ReleaseReceivePacket(ring)
+	}
+}
+
+func applyTransforms(transforms []PacketTransform, packet []byte) ([]byte, bool) {
+	for _, transform := range transforms {
+		var ok bool
+		packet, ok = transform(packet)
+		if !ok {
+			return nil, false
+		}
+	}
+	return packet, true
+}
+
+// Send sends a copy of packet, after applying the transforms registered with
+// AddSendTransform, which may modify packet. It returns nil without sending
+// anything if a transform drops the packet.
+func (session Session) Send(packet []byte) error {
+	return session.send(packet, session.AllocateSendPacket)
+}
+
+// SendContext is like Send, but when the ring is full it waits for room, as
+// AllocateSendPacketContext does, until ctx is done, in which case nothing is
+// sent and ctx.Err() is returned.
+func (session Session) SendContext(ctx context.Context, packet []byte) error {
+	return session.send(packet, func(packetSize int) ([]byte, error) {
+		return session.AllocateSendPacketContext(ctx, packetSize)
+	})
+}
+
+func (session Session) send(packet []byte, allocate func(packetSize int) ([]byte, error)) error {
+	state := session.state
+	state.transformsMu.RLock()
+	transforms := state.sendTransforms
+	state.transformsMu.RUnlock()
+	packet, ok := applyTransforms(transforms, packet)
+	if !ok {
+		atomic.AddUint64(&state.counters.sendDropped, 1)
+		return nil
+	}
+	if len(packet) == 0 || len(packet) > PacketSizeMax {
+		return windows.ERROR_INVALID_PARAMETER
+	}
+	buf, err := allocate(len(packet))
+	if err != nil {
+		return err
+	}
+	copy(buf, packet)
+	session.SendPacket(buf)
+	return nil
+}
+
+// ErrFamilyMismatch is returned by SendWithFamily when the IP version of a
+// packet does not match the declared address family.
+var ErrFamilyMismatch = errors.New("Packet IP version does not match its address family")
+
+// SendWithFamily is like Send, but first checks that the version of packet's
+// IP header matches family, windows.AF_INET or windows.AF_INET6, returning
+// ErrFamilyMismatch if it does not. The driver infers the family from the
+// version alone, so a packet built for the wrong family would otherwise be
+// misinterpreted rather than rejected.
+func (session Session) SendWithFamily(family int, packet []byte) error {
+	var version byte
+	switch family {
+	case windows.AF_INET:
+		version = 4
+	case windows.AF_INET6:
+		version = 6
+	default:
+		return windows.ERROR_INVALID_PARAMETER
+	}
+	if len(packet) == 0 || packet[0]>>4 != version {
+		return ErrFamilyMismatch
+	}
+	return session.Send(packet)
+}
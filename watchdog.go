@@ -0,0 +1,73 @@
+//go:build windows
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package wintun
+
+import (
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sys/windows"
+)
+
+// StartWatchdog starts monitoring the session for a stalled data path. Every
+// interval, it checks whether the read-wait event is signaled even though no
+// packet has been received for at least interval. When that happens on two
+// checks in a row, a warning is logged and onStall, if not nil, is called,
+// typically to restart the session. The event is auto-reset, so checking it
+// consumes the signal; the watchdog signals it again right away, so that a
+// reader about to wait still wakes up, and gives it a whole interval to do so
+// before reporting a stall. The watchdog assumes the session is being read
+// continuously, and runs until stop is called or the session ends.
+func (session Session) StartWatchdog(interval time.Duration, onStall func()) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		var suspect int64 // lastReceive when the event was last found signaled
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			lastReceive := atomic.LoadInt64(&session.state.lastReceive)
+			idle := time.Since(time.Unix(0, lastReceive))
+			if idle < interval {
+				suspect = 0
+				continue
+			}
+			if !session.state.acquire() {
+				return
+			}
+			event := session.ReadWaitEvent()
+			signaled, _ := windows.WaitForSingleObject(event, 0)
+			if signaled == windows.WAIT_OBJECT_0 {
+				windows.SetEvent(event)
+			}
+			session.state.release()
+			if signaled != windows.WAIT_OBJECT_0 {
+				suspect = 0
+				continue
+			}
+			if suspect != lastReceive {
+				suspect = lastReceive
+				continue
+			}
+			log.Printf("Wintun session appears stalled: no packets received for %v despite read-wait event being signaled%s", idle.Round(time.Millisecond), session.state.adapter.logTags())
+			if onStall != nil {
+				onStall()
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(done) })
+	}
+}
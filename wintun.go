@@ -8,9 +8,18 @@
 package wintun
 
 import (
+	"errors"
+	"fmt"
 	"log"
+	"os"
 	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
+	"time"
 	"unsafe"
 
 	"golang.org/x/sys/windows"
@@ -26,8 +35,16 @@ const (
 
 const AdapterNameMax = 128
 
+// ErrNameAlreadyExists is returned by CreateAdapter when another adapter
+// already has the requested name. Use OpenAdapter to use that adapter instead.
+var ErrNameAlreadyExists = errors.New("An adapter with this name already exists")
+
 type Adapter struct {
-	handle uintptr
+	handle  uintptr
+	created bool  // Whether closing the adapter removes it
+	closed  int32 // Set to 1 by Close, accessed atomically
+	tagsMu  sync.Mutex
+	tags    map[string]string
 }
 
 var (
@@ -44,16 +61,145 @@ type TimestampedWriter interface {
 	WriteWithTimestamp(p []byte, ts int64) (n int, err error)
 }
 
+// filetimeUnixEpoch is the Unix epoch expressed as a FILETIME, in 100-nanosecond
+// intervals since January 1, 1601 UTC.
+const filetimeUnixEpoch = 116444736000000000
+
+// FiletimeToTime converts a FILETIME, split into its low and high halves, to a
+// time.Time. The whole FILETIME range, from 1601 to 30828, is supported.
+func FiletimeToTime(low, high uint32) time.Time {
+	ft := uint64(high)<<32 | uint64(low)
+	if ft >= filetimeUnixEpoch {
+		d := ft - filetimeUnixEpoch
+		return time.Unix(int64(d/1e7), int64(d%1e7)*100)
+	}
+	d := filetimeUnixEpoch - ft
+	return time.Unix(-int64(d/1e7), -int64(d%1e7)*100)
+}
+
+// TimeToFiletime converts t to a FILETIME, split into its low and high halves.
+func TimeToFiletime(t time.Time) (low, high uint32) {
+	ft := t.Unix()*1e7 + int64(t.Nanosecond()/100) + filetimeUnixEpoch
+	return uint32(ft), uint32(ft >> 32)
+}
+
 func logMessage(level loggerLevel, timestamp uint64, msg *uint16) int {
+	// This runs on a driver thread, where a panicking logger would bring down
+	// the whole process.
+	defer func() {
+		if err := recover(); err != nil {
+			fmt.Fprintf(os.Stderr, "Wintun logger panicked: %v: %s\n", err, windows.UTF16PtrToString(msg))
+		}
+	}()
+	text := windows.UTF16PtrToString(msg)
+	ts := FiletimeToTime(uint32(timestamp), uint32(timestamp>>32))
+	repeated := logCoalescer.filter(text, ts)
+	if repeated > 0 {
+		writeRepeated(repeated, ts)
+	}
+	if repeated >= 0 {
+		writeLog(text, ts)
+	}
+	return 0
+}
+
+func writeLog(text string, ts time.Time) {
 	if tw, ok := log.Default().Writer().(TimestampedWriter); ok {
-		tw.WriteWithTimestamp([]byte(log.Default().Prefix()+windows.UTF16PtrToString(msg)), (int64(timestamp)-116444736000000000)*100)
+		tw.WriteWithTimestamp([]byte(log.Default().Prefix()+text), ts.UnixNano())
 	} else {
-		log.Println(windows.UTF16PtrToString(msg))
+		log.Println(text)
+	}
+}
+
+func writeRepeated(repeated int, ts time.Time) {
+	writeLog(fmt.Sprintf("(previous message repeated %d times)", repeated), ts)
+}
+
+// logCoalescing suppresses driver log messages identical to the previous one.
+type logCoalescing struct {
+	mu       sync.Mutex
+	window   time.Duration
+	last     string
+	since    time.Time
+	repeated int
+	flush    *time.Timer // Writes the repetitions once a window passes without messages
+}
+
+var logCoalescer logCoalescing
+
+// filter returns -1 if the message is to be suppressed, and otherwise how
+// many repetitions of the previous message were suppressed before it.
+func (c *logCoalescing) filter(text string, ts time.Time) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.window <= 0 {
+		return 0
+	}
+	if text == c.last && ts.Sub(c.since) < c.window {
+		c.repeated++
+		if c.flush == nil {
+			c.flush = time.AfterFunc(c.window, c.flushRepeated)
+		} else {
+			c.flush.Reset(c.window)
+		}
+		return -1
+	}
+	repeated := c.repeated
+	c.last, c.since, c.repeated = text, ts, 0
+	return repeated
+}
+
+// takeRepeated returns the number of suppressed repetitions not written yet,
+// and forgets the previous message, so that the next one is written whatever
+// it is. c.mu must be held.
+func (c *logCoalescing) takeRepeated() int {
+	repeated := c.repeated
+	c.last, c.repeated = "", 0
+	if c.flush != nil {
+		c.flush.Stop()
+	}
+	return repeated
+}
+
+func (c *logCoalescing) flushRepeated() {
+	defer func() {
+		if err := recover(); err != nil {
+			fmt.Fprintf(os.Stderr, "Wintun logger panicked: %v\n", err)
+		}
+	}()
+	c.mu.Lock()
+	repeated := c.takeRepeated()
+	c.mu.Unlock()
+	if repeated > 0 {
+		writeRepeated(repeated, time.Now())
+	}
+}
+
+// SetLogCoalescing sets a window within which driver log messages identical to
+// the previous one are suppressed; the next different message, or the next
+// identical one after the window, is preceded by a line telling how many times
+// the previous message was repeated. That line is also written once a window
+// passes without any message, and when coalescing is set again, so that the
+// count of a storm that stopped is not lost. This keeps logs readable when the
+// driver repeats an error many times over. A window of zero, the default,
+// disables coalescing.
+func SetLogCoalescing(window time.Duration) {
+	logCoalescer.mu.Lock()
+	logCoalescer.window = window
+	repeated := logCoalescer.takeRepeated()
+	logCoalescer.mu.Unlock()
+	if repeated > 0 {
+		writeRepeated(repeated, time.Now())
 	}
-	return 0
 }
 
 func setupLogger(dll *lazyDLL) {
+	// Logging is best effort; a DLL without the logger export still works.
+	proc := dll.NewProc("WintunSetLogger")
+	if err := proc.Find(); err != nil {
+		log.Printf("Wintun driver logging unavailable: %v", err)
+		return
+	}
 	var callback uintptr
 	if runtime.GOARCH == "386" {
 		callback = windows.NewCallback(func(level loggerLevel, timestampLow, timestampHigh uint32, msg *uint16) int {
@@ -66,18 +212,60 @@ func setupLogger(dll *lazyDLL) {
 	} else if runtime.GOARCH == "amd64" || runtime.GOARCH == "arm64" {
 		callback = windows.NewCallback(logMessage)
 	}
-	syscall.Syscall(dll.NewProc("WintunSetLogger").Addr(), 1, callback, 0, 0)
+	callProc(proc, nil, callback)
 }
 
 func closeAdapter(wintun *Adapter) {
-	syscall.Syscall(procWintunCloseAdapter.Addr(), 1, wintun.handle, 0, 0)
+	if wintun.created && !liveAdapters.take(wintun.handle) {
+		// Already removed by the shutdown hook.
+		return
+	}
+	callProc(procWintunCloseAdapter, nil, wintun.handle)
+}
+
+// AdapterConfig describes an adapter to create. Name, TunnelType and
+// RequestedGUID have the same meaning as the arguments of CreateAdapter.
+type AdapterConfig struct {
+	Name          string
+	TunnelType    string
+	RequestedGUID *windows.GUID
+
+	// WaitForIndex, if not zero, is how long to wait after creating the
+	// adapter for the system to assign it an interface index. Until then,
+	// operations that need the index, such as LUID.Index, fail.
+	WaitForIndex time.Duration
+}
+
+// CreateAdapterWithConfig creates a Wintun adapter as described by config.
+func CreateAdapterWithConfig(config AdapterConfig) (*Adapter, error) {
+	wintun, err := CreateAdapter(config.Name, config.TunnelType, config.RequestedGUID)
+	if err != nil {
+		return nil, err
+	}
+	if config.WaitForIndex == 0 {
+		return wintun, nil
+	}
+	deadline := time.Now().Add(config.WaitForIndex)
+	for {
+		_, err = LUID(wintun.LUID()).Index()
+		if err == nil {
+			return wintun, nil
+		}
+		if !time.Now().Before(deadline) {
+			wintun.Close()
+			return nil, err
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
 }
 
 // CreateAdapter creates a Wintun adapter. name is the cosmetic name of the adapter.
 // tunnelType represents the type of adapter and should be "Wintun". requestedGUID is
 // the GUID of the created network adapter, which then influences NLA generation
 // deterministically. If it is set to nil, the GUID is chosen by the system at random,
-// and hence a new NLA entry is created for each new adapter.
+// and hence a new NLA entry is created for each new adapter. If an adapter
+// named name already exists, ErrNameAlreadyExists is returned, unless its GUID
+// is requestedGUID, in which case the driver recreates that adapter.
 func CreateAdapter(name string, tunnelType string, requestedGUID *windows.GUID) (wintun *Adapter, err error) {
 	var name16 *uint16
 	name16, err = windows.UTF16PtrFromString(name)
@@ -92,16 +280,52 @@ func CreateAdapter(name string, tunnelType string, requestedGUID *windows.GUID)
 	if err := procWintunCreateAdapter.Find(); err != nil {
 		return nil, err
 	}
-	r0, _, e1 := syscall.Syscall(procWintunCreateAdapter.Addr(), 3, uintptr(unsafe.Pointer(name16)), uintptr(unsafe.Pointer(tunnelType16)), uintptr(unsafe.Pointer(requestedGUID)))
+	var r0 uintptr
+	var e1 syscall.Errno
+	throttlePnP(func() {
+		// Check under the throttle, so that no adapter of this process
+		// appears or disappears in between.
+		err = checkAdapterName(name, requestedGUID)
+		if err != nil {
+			return
+		}
+		r0, _, e1 = callProc(procWintunCreateAdapter, []unsafe.Pointer{unsafe.Pointer(name16), unsafe.Pointer(tunnelType16), unsafe.Pointer(requestedGUID)}, uintptr(unsafe.Pointer(name16)), uintptr(unsafe.Pointer(tunnelType16)), uintptr(unsafe.Pointer(requestedGUID)))
+	})
+	if err != nil {
+		return nil, err
+	}
 	if r0 == 0 {
 		err = e1
 		return
 	}
-	wintun = &Adapter{handle: r0}
+	wintun = &Adapter{handle: r0, created: true}
 	runtime.SetFinalizer(wintun, closeAdapter)
+	liveAdapters.add(r0)
 	return
 }
 
+// checkAdapterName returns ErrNameAlreadyExists if an adapter named name exists
+// and its GUID is not requestedGUID. Only a missing adapter counts as absent;
+// any other failure to open it, such as access denied, is returned.
+func checkAdapterName(name string, requestedGUID *windows.GUID) error {
+	existing, err := OpenAdapter(name)
+	if err == windows.ERROR_FILE_NOT_FOUND || err == windows.ERROR_NOT_FOUND {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	guid, err := existing.guid()
+	existing.Close()
+	if err != nil {
+		return err
+	}
+	if requestedGUID == nil || guid != *requestedGUID {
+		return ErrNameAlreadyExists
+	}
+	return nil
+}
+
 // OpenAdapter opens an existing Wintun adapter by name.
 func OpenAdapter(name string) (wintun *Adapter, err error) {
 	var name16 *uint16
@@ -112,7 +336,7 @@ func OpenAdapter(name string) (wintun *Adapter, err error) {
 	if err := procWintunOpenAdapter.Find(); err != nil {
 		return nil, err
 	}
-	r0, _, e1 := syscall.Syscall(procWintunOpenAdapter.Addr(), 1, uintptr(unsafe.Pointer(name16)), 0, 0)
+	r0, _, e1 := callProc(procWintunOpenAdapter, []unsafe.Pointer{unsafe.Pointer(name16)}, uintptr(unsafe.Pointer(name16)))
 	if r0 == 0 {
 		err = e1
 		return
@@ -122,13 +346,30 @@ func OpenAdapter(name string) (wintun *Adapter, err error) {
 	return
 }
 
-// Close closes a Wintun adapter.
+// Close closes a Wintun adapter. Closing an adapter created by CreateAdapter
+// removes it, subject to the throttle set by SetCreateThrottle. Closing an
+// adapter again does nothing.
 func (wintun *Adapter) Close() (err error) {
 	if err := procWintunCloseAdapter.Find(); err != nil {
 		return err
 	}
+	if !atomic.CompareAndSwapInt32(&wintun.closed, 0, 1) {
+		return nil
+	}
 	runtime.SetFinalizer(wintun, nil)
-	r1, _, e1 := syscall.Syscall(procWintunCloseAdapter.Addr(), 1, wintun.handle, 0, 0)
+	var r1 uintptr
+	var e1 syscall.Errno
+	if wintun.created {
+		if !liveAdapters.take(wintun.handle) {
+			// Already removed by the shutdown hook.
+			return nil
+		}
+		throttlePnP(func() {
+			r1, _, e1 = callProc(procWintunCloseAdapter, nil, wintun.handle)
+		})
+	} else {
+		r1, _, e1 = callProc(procWintunCloseAdapter, nil, wintun.handle)
+	}
 	if r1 == 0 {
 		err = e1
 	}
@@ -136,23 +377,47 @@ func (wintun *Adapter) Close() (err error) {
 }
 
 // Uninstall removes the driver from the system if no drivers are currently in use.
+// It returns ErrMissingDriverPrivilege without trying if the process lacks the
+// privilege to unload drivers.
 func Uninstall() (err error) {
+	if ok, err := HasDriverPrivilege(); err == nil && !ok {
+		return ErrMissingDriverPrivilege
+	}
 	if err := procWintunDeleteDriver.Find(); err != nil {
 		return err
 	}
-	r1, _, e1 := syscall.Syscall(procWintunDeleteDriver.Addr(), 0, 0, 0, 0)
+	r1, _, e1 := callProc(procWintunDeleteDriver, nil)
 	if r1 == 0 {
 		err = e1
 	}
 	return
 }
 
+// WaitDriverUnloaded polls until the driver is no longer loaded, which is
+// typically shortly after a successful call to Uninstall. It returns
+// windows.WAIT_TIMEOUT if the driver is still loaded once timeout has elapsed.
+func WaitDriverUnloaded(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		_, err := RunningVersion()
+		if err == windows.ERROR_FILE_NOT_FOUND {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if !time.Now().Before(deadline) {
+			return windows.WAIT_TIMEOUT
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
 // RunningVersion returns the version of the loaded driver.
 func RunningVersion() (version uint32, err error) {
 	if err := procWintunGetRunningDriverVersion.Find(); err != nil {
 		return 0, err
 	}
-	r0, _, e1 := syscall.Syscall(procWintunGetRunningDriverVersion.Addr(), 0, 0, 0, 0)
+	r0, _, e1 := callProc(procWintunGetRunningDriverVersion, nil)
 	version = uint32(r0)
 	if version == 0 {
 		err = e1
@@ -162,6 +427,59 @@ func RunningVersion() (version uint32, err error) {
 
 // LUID returns the LUID of the adapter.
 func (wintun *Adapter) LUID() (luid uint64) {
-	syscall.Syscall(procWintunGetAdapterLUID.Addr(), 2, uintptr(wintun.handle), uintptr(unsafe.Pointer(&luid)), 0)
+	callProc(procWintunGetAdapterLUID, []unsafe.Pointer{unsafe.Pointer(&luid)}, uintptr(wintun.handle), uintptr(unsafe.Pointer(&luid)))
 	return
 }
+
+// guid returns the network GUID of the adapter.
+func (wintun *Adapter) guid() (windows.GUID, error) {
+	return LUID(wintun.LUID()).GUID()
+}
+
+// SetTag attaches an arbitrary key/value pair to the adapter, for correlating
+// it in logs and metrics. Tags are appended to the warnings the package logs
+// about the adapter's sessions, such as stalls and failed captures, and become
+// labels of the metrics written by WriteMetrics. Tags are kept in memory only
+// and are never passed to the driver. An empty value removes the tag.
+func (wintun *Adapter) SetTag(key, value string) {
+	wintun.tagsMu.Lock()
+	defer wintun.tagsMu.Unlock()
+	if value == "" {
+		delete(wintun.tags, key)
+		return
+	}
+	if wintun.tags == nil {
+		wintun.tags = make(map[string]string)
+	}
+	wintun.tags[key] = value
+}
+
+// logTags returns the adapter's tags formatted for a log line, sorted by key
+// and preceded by a space, or an empty string if it has none.
+func (wintun *Adapter) logTags() string {
+	tags := wintun.Tags()
+	if len(tags) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	fields := make([]string, len(keys))
+	for i, key := range keys {
+		fields[i] = strconv.Quote(key) + "=" + strconv.Quote(tags[key])
+	}
+	return " [" + strings.Join(fields, " ") + "]"
+}
+
+// Tags returns a copy of the tags attached to the adapter with SetTag.
+func (wintun *Adapter) Tags() map[string]string {
+	wintun.tagsMu.Lock()
+	defer wintun.tagsMu.Unlock()
+	tags := make(map[string]string, len(wintun.tags))
+	for key, value := range wintun.tags {
+		tags[key] = value
+	}
+	return tags
+}
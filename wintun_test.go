@@ -0,0 +1,197 @@
+//go:build windows
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2021 WireGuard LLC. All Rights Reserved.
+ */
+
+package wintun
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/windows"
+)
+
+const testAdapterName = "WintunGoTest"
+
+// requireDriver skips the test unless the process is elevated and can load
+// wintun.dll, as creating adapters requires.
+func requireDriver(t testing.TB) {
+	t.Helper()
+	if !windows.GetCurrentProcessToken().IsElevated() {
+		t.Skip("Creating adapters requires an elevated process")
+	}
+	if err := modwintun.Load(); err != nil {
+		t.Skipf("Wintun DLL unavailable: %v", err)
+	}
+}
+
+// createTestAdapter creates an adapter that is removed when the test ends.
+func createTestAdapter(t testing.TB, name string, requestedGUID *windows.GUID) *Adapter {
+	t.Helper()
+	requireDriver(t)
+	wintun, err := CreateAdapter(name, "Wintun", requestedGUID)
+	if err != nil {
+		t.Fatalf("CreateAdapter(%q) failed: %v", name, err)
+	}
+	t.Cleanup(func() { wintun.Close() })
+	return wintun
+}
+
+func TestCreateAdapterExistingName(t *testing.T) {
+	guid := windows.GUID{Data1: 0x7a2c4b1e, Data2: 0x51d3, Data3: 0x4f0a, Data4: [8]byte{0x9c, 0x61, 0x2e, 0x0b, 0x83, 0x47, 0xd5, 0x16}}
+	otherGUID := windows.GUID{Data1: 0x0e94c2d7, Data2: 0x3b68, Data3: 0x4a5f, Data4: [8]byte{0xa1, 0x0d, 0x7c, 0x52, 0xe9, 0x34, 0x6b, 0x88}}
+	createTestAdapter(t, testAdapterName, &guid)
+
+	tests := []struct {
+		name    string
+		guid    *windows.GUID
+		wantErr error
+	}{
+		{"same name, same GUID", &guid, nil},
+		{"same name, other GUID", &otherGUID, ErrNameAlreadyExists},
+		{"same name, no GUID", nil, ErrNameAlreadyExists},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			wintun, err := CreateAdapter(testAdapterName, "Wintun", test.guid)
+			if err != test.wantErr {
+				t.Fatalf("CreateAdapter returned %v, want %v", err, test.wantErr)
+			}
+			if wintun != nil {
+				t.Cleanup(func() { wintun.Close() })
+			}
+		})
+	}
+}
+
+func TestCreateAdapterNewName(t *testing.T) {
+	createTestAdapter(t, testAdapterName, nil)
+	wintun := createTestAdapter(t, testAdapterName+"2", nil)
+	if _, err := wintun.guid(); err != nil {
+		t.Fatalf("New adapter has no GUID: %v", err)
+	}
+}
+
+func TestDeviceInstanceID(t *testing.T) {
+	wintun := createTestAdapter(t, testAdapterName, nil)
+	instanceID, err := wintun.DeviceInstanceID()
+	if err != nil {
+		t.Fatalf("DeviceInstanceID failed: %v", err)
+	}
+	if !strings.HasPrefix(strings.ToUpper(instanceID), `SWD\WINTUN\`) {
+		t.Errorf("DeviceInstanceID = %q, want an SWD\\WINTUN\\ instance", instanceID)
+	}
+}
+
+func TestFiletimeToTime(t *testing.T) {
+	tests := []struct {
+		name      string
+		low, high uint32
+		want      time.Time
+	}{
+		{"zero", 0, 0, time.Date(1601, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"1601", 10000000, 0, time.Date(1601, 1, 1, 0, 0, 1, 0, time.UTC)},
+		{"Unix epoch", 0xd53e8000, 0x019db1de, time.Unix(0, 0)},
+		{"one tick after the Unix epoch", 0xd53e8001, 0x019db1de, time.Unix(0, 100)},
+		{"one tick before the Unix epoch", 0xd53e7fff, 0x019db1de, time.Unix(0, -100)},
+		{"maximum", 0xffffffff, 0x7fffffff, time.Date(30828, 9, 14, 2, 48, 5, 477580700, time.UTC)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := FiletimeToTime(test.low, test.high); !got.Equal(test.want) {
+				t.Errorf("FiletimeToTime(%#x, %#x) = %v, want %v", test.low, test.high, got.UTC(), test.want.UTC())
+			}
+			if low, high := TimeToFiletime(test.want); low != test.low || high != test.high {
+				t.Errorf("TimeToFiletime(%v) = %#x, %#x, want %#x, %#x", test.want.UTC(), low, high, test.low, test.high)
+			}
+		})
+	}
+}
+
+func TestFiletimeRoundTrip(t *testing.T) {
+	for _, want := range []time.Time{
+		time.Date(1677, 1, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(2024, 2, 29, 12, 30, 0, 123456700, time.UTC),
+		time.Date(2262, 4, 12, 0, 0, 0, 0, time.UTC),
+		time.Date(9999, 12, 31, 23, 59, 59, 999999900, time.UTC),
+	} {
+		if got := FiletimeToTime(TimeToFiletime(want)); !got.Equal(want) {
+			t.Errorf("FiletimeToTime(TimeToFiletime(%v)) = %v", want, got.UTC())
+		}
+	}
+}
+
+type panickingWriter struct{}
+
+func (panickingWriter) Write(p []byte) (int, error) {
+	panic("logger failure")
+}
+
+func TestLogMessagePanickingLogger(t *testing.T) {
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(panickingWriter{})
+	msg, _ := windows.UTF16PtrFromString("Test message")
+	for i := 0; i < 3; i++ {
+		if ret := logMessage(logErr, 0, msg); ret != 0 {
+			t.Fatalf("logMessage returned %d, want 0", ret)
+		}
+	}
+}
+
+func TestSetupLoggerMissingProc(t *testing.T) {
+	var buf bytes.Buffer
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(&buf)
+	// kernel32.dll loads from System32 but has no WintunSetLogger export.
+	setupLogger(newLazyDLL("kernel32.dll", nil))
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 1 || !strings.Contains(lines[0], "logging unavailable") {
+		t.Fatalf("setupLogger logged %q, want a single warning", buf.String())
+	}
+}
+
+// lockedBuffer is a bytes.Buffer safe for writing from timer goroutines.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestLogCoalescingFlushesAfterWindow(t *testing.T) {
+	var buf lockedBuffer
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(&buf)
+	defer SetLogCoalescing(0)
+	SetLogCoalescing(20 * time.Millisecond)
+	msg, _ := windows.UTF16PtrFromString("Repeated message")
+	low, high := TimeToFiletime(time.Now())
+	for i := 0; i < 5; i++ {
+		logMessage(logErr, uint64(high)<<32|uint64(low), msg)
+	}
+	time.Sleep(200 * time.Millisecond)
+	out := buf.String()
+	if n := strings.Count(out, "Repeated message"); n != 1 {
+		t.Errorf("Message logged %d times, want 1: %q", n, out)
+	}
+	if !strings.Contains(out, "(previous message repeated 4 times)") {
+		t.Errorf("Repetition count not flushed: %q", out)
+	}
+}